@@ -0,0 +1,214 @@
+package repmeta
+
+import (
+	"fmt"
+)
+
+// ColumnSpec.CalcType values
+const (
+	CalcNone         = "none"
+	CalcSum          = "sum"
+	CalcAvg          = "avg"
+	CalcMin          = "min"
+	CalcMax          = "max"
+	CalcCount        = "count"
+	CalcCountNonNull = "count_nonnull"
+)
+
+func CalcTypes() []string {
+	return []string{CalcNone, CalcSum, CalcAvg, CalcMin, CalcMax, CalcCount, CalcCountNonNull}
+}
+
+func IsCalcType(s string) bool {
+	for _, calc := range CalcTypes() {
+		if s == calc {
+			return true
+		}
+	}
+	return false
+}
+
+// CalcResultType is the type of a total computed by calc over values of
+// srcType, DVNone when the calc does not apply to the type
+func CalcResultType(calc string, srcType DataValType) DataValType {
+	isNumeric := Numerics()
+	switch calc {
+	case CalcSum:
+		if isNumeric[srcType] {
+			return srcType
+		}
+	case CalcAvg:
+		if srcType == DVCurrency {
+			return DVCurrency
+		}
+		if isNumeric[srcType] {
+			return DVFloat
+		}
+	case CalcMin, CalcMax:
+		return srcType
+	case CalcCount, CalcCountNonNull:
+		return DVInt
+	}
+	return DVNone
+}
+
+// Aggregate accumulates the total of one column within a ReportLevel
+type Aggregate struct {
+	CalcType string
+	SrcType  DataValType
+	Result   *DataVal
+	count    int64
+	nonNull  int64
+	sumInt   int64
+	sumFloat float64
+}
+
+func NewAggregate(calc string, srcType DataValType) *Aggregate {
+	agg := Aggregate{CalcType: calc, SrcType: srcType}
+	agg.Result = NewDVNone()
+	agg.Reset()
+	return &agg
+}
+
+// NewAggregates allocates an Aggregate for every column of a DataRow
+func NewAggregates(spec *ReportSpec) ([]*Aggregate, error) {
+	var allAggs []*Aggregate
+	allCalcs := spec.ColumnCalcs()
+	for colIdx, column := range spec.AllColumns() {
+		// computed columns are evaluated over the totals instead
+		if cs := spec.computedAt(colIdx); cs != nil {
+			allAggs = append(allAggs, NewAggregate(CalcNone, cs.computedType()))
+			continue
+		}
+		_, pFld := spec.ColumnNamed(column)
+		if pFld == nil {
+			return nil, fmt.Errorf("Unable to total column named %q", column)
+		}
+		srcType := ToDataValType(pFld.FldType)
+		allAggs = append(allAggs, NewAggregate(allCalcs[colIdx], srcType))
+	}
+	return allAggs, nil
+}
+
+func (agg *Aggregate) String() string {
+	return fmt.Sprintf("%s(%s) = %q", agg.CalcType, agg.Result.FromDataVal(), agg.Result.String())
+}
+
+func (agg *Aggregate) Reset() {
+	agg.count = 0
+	agg.nonNull = 0
+	agg.sumInt = 0
+	agg.sumFloat = 0
+	agg.Result.Typ = CalcResultType(agg.CalcType, agg.SrcType)
+	agg.Result.ResetAll()
+	// counts start at zero, every other total is null until a value arrives
+	if agg.CalcType == CalcCount || agg.CalcType == CalcCountNonNull {
+		agg.Result.SetInt64(0)
+	}
+}
+
+// NonNullCount is the number of non-null values accumulated
+func (agg *Aggregate) NonNullCount() int64 {
+	return agg.nonNull
+}
+
+// Accumulate folds dv into the total, returning false when dv cannot be
+// combined with this aggregate
+func (agg *Aggregate) Accumulate(dv *DataVal) bool {
+	if agg.Result.Typ == DVNone {
+		return true
+	}
+	if dv.Typ != agg.SrcType {
+		return false
+	}
+	agg.count++
+	if agg.CalcType == CalcCount {
+		agg.Result.SetInt64(agg.count)
+		return true
+	}
+	if dv.IsNull() {
+		return true
+	}
+	agg.nonNull++
+
+	switch agg.CalcType {
+	case CalcCountNonNull:
+		agg.Result.SetInt64(agg.nonNull)
+	case CalcSum:
+		agg.Result.DidAccumulate(dv)
+	case CalcAvg:
+		agg.addToSum(dv)
+		agg.setAverage()
+	case CalcMin:
+		if agg.nonNull == 1 || dv.Compare(agg.Result) < 0 {
+			agg.Result.CopyFrom(dv)
+		}
+	case CalcMax:
+		if agg.nonNull == 1 || dv.Compare(agg.Result) > 0 {
+			agg.Result.CopyFrom(dv)
+		}
+	}
+	return true
+}
+
+// Merge folds the total of other, of the same calc and type, into this
+// one, as though its values had been accumulated here as well
+func (agg *Aggregate) Merge(other *Aggregate) {
+	if agg.Result.Typ == DVNone {
+		return
+	}
+	agg.count += other.count
+	agg.nonNull += other.nonNull
+	agg.sumInt += other.sumInt
+	agg.sumFloat += other.sumFloat
+
+	switch agg.CalcType {
+	case CalcCount:
+		agg.Result.SetInt64(agg.count)
+	case CalcCountNonNull:
+		agg.Result.SetInt64(agg.nonNull)
+	case CalcSum:
+		agg.Result.DidAccumulate(other.Result)
+	case CalcAvg:
+		agg.setAverage()
+	case CalcMin:
+		if !other.Result.IsNull() && (agg.Result.IsNull() || other.Result.Compare(agg.Result) < 0) {
+			agg.Result.CopyFrom(other.Result)
+		}
+	case CalcMax:
+		if !other.Result.IsNull() && (agg.Result.IsNull() || other.Result.Compare(agg.Result) > 0) {
+			agg.Result.CopyFrom(other.Result)
+		}
+	}
+}
+
+func (agg *Aggregate) addToSum(dv *DataVal) {
+	switch dv.Typ {
+	case DVInt, DVCurrency:
+		val, _ := dv.Int64()
+		agg.sumInt += val
+	case DVFloat:
+		val, _ := dv.Float64()
+		agg.sumFloat += val
+	}
+}
+
+func (agg *Aggregate) setAverage() {
+	if agg.nonNull == 0 {
+		return
+	}
+	switch agg.SrcType {
+	case DVInt:
+		agg.Result.SetFloat64(float64(agg.sumInt) / float64(agg.nonNull))
+	case DVFloat:
+		agg.Result.SetFloat64(agg.sumFloat / float64(agg.nonNull))
+	case DVCurrency:
+		// round to the nearest penny
+		avg := float64(agg.sumInt) / float64(agg.nonNull)
+		if avg < 0 {
+			agg.Result.SetInt64(int64(avg - 0.5))
+		} else {
+			agg.Result.SetInt64(int64(avg + 0.5))
+		}
+	}
+}
@@ -0,0 +1,244 @@
+package repmeta
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// colExpr evaluates a computed column over a DataRow, which may be a
+// detail row or a row of totals. It returns false for a null result.
+type colExpr func(dR *DataRow) (float64, bool)
+
+// computedColumn is a ColumnSpec with an Expr, compiled against the
+// columns of a DataRow
+type computedColumn struct {
+	colIdx int
+	typ    DataValType
+	eval   colExpr
+}
+
+// compileComputed compiles the Expr of every computed column. An Expr
+// may refer to any numeric column that is not computed, and to the
+// computed columns listed before it.
+func compileComputed(spec *ReportSpec) ([]computedColumn, error) {
+	allComputed := []computedColumn{}
+	for idx, cs := range spec.Columns {
+		if !cs.IsComputed() {
+			continue
+		}
+		if !Numerics()[cs.computedType()] {
+			return nil, fmt.Errorf("Computed column %q must be int, float or currency, not %q", cs.FldName, cs.FldType)
+		}
+		eval, err := compileColumn(spec, idx)
+		if err != nil {
+			return nil, fmt.Errorf("Expression of column %q: %s", cs.FldName, err.Error())
+		}
+		allComputed = append(allComputed, computedColumn{colIdx: len(spec.ExtraColumns) + idx, typ: cs.computedType(), eval: eval})
+	}
+	return allComputed, nil
+}
+
+// compileColumn compiles the Expr of spec.Columns[specIdx]
+func compileColumn(spec *ReportSpec, specIdx int) (colExpr, error) {
+	numExtra := len(spec.ExtraColumns)
+	colIdx := numExtra + specIdx
+	resolve := func(name string) (int, error) {
+		for refIdx, column := range spec.AllColumns() {
+			if column != name {
+				continue
+			}
+			if spec.computedAt(refIdx) != nil {
+				if refIdx == colIdx {
+					return -1, fmt.Errorf("Column %q refers to itself", name)
+				}
+				if refIdx > colIdx {
+					return -1, fmt.Errorf("Computed column %q must be listed before %q", name, spec.Columns[specIdx].FldName)
+				}
+				return refIdx, nil
+			}
+			_, pFld := spec.Dataset.FieldNamed(name)
+			if pFld == nil || !Numerics()[ToDataValType(pFld.FldType)] {
+				return -1, fmt.Errorf("Column %q is not numeric", name)
+			}
+			return refIdx, nil
+		}
+		return -1, fmt.Errorf("%q is not a column of the report", name)
+	}
+	return parseColExpr(spec.Columns[specIdx].Expr, resolve)
+}
+
+// computeColumns sets every computed column of dR, in order
+func computeColumns(allComputed []computedColumn, dR *DataRow) {
+	for _, cc := range allComputed {
+		result := NewDataValTyped(cc.typ)
+		val, ok := cc.eval(dR)
+		if ok && !math.IsNaN(val) && !math.IsInf(val, 0) {
+			switch cc.typ {
+			case DVInt, DVCurrency:
+				result.SetInt64(int64(math.Round(val)))
+			case DVFloat:
+				result.SetFloat64(val)
+			}
+		}
+		(*dR)[cc.colIdx].CopyFrom(result)
+	}
+}
+
+// numericValue reads a column for an expression, currency in pennies
+func numericValue(dv *DataVal) (float64, bool) {
+	if dv.IsNull() {
+		return 0, false
+	}
+	switch dv.Typ {
+	case DVInt, DVCurrency:
+		val, _ := dv.Int64()
+		return float64(val), true
+	case DVFloat:
+		return dv.Float64()
+	}
+	return 0, false
+}
+
+// colExprParser parses + - * / over numbers and column names, with
+// parentheses and unary minus
+type colExprParser struct {
+	allTokens []exprToken
+	pos       int
+	resolve   func(name string) (int, error)
+}
+
+func parseColExpr(expr string, resolve func(name string) (int, error)) (colExpr, error) {
+	allTokens, err := tokenizeExpr(expr)
+	if err != nil {
+		return nil, err
+	}
+	p := colExprParser{allTokens: allTokens, resolve: resolve}
+	eval, err := p.parseSum()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.allTokens) {
+		tok := p.allTokens[p.pos]
+		return nil, fmt.Errorf("Unexpected %q at position %d", tok.text, tok.pos)
+	}
+	return eval, nil
+}
+
+// nextOp consumes the next token when it is one of ops
+func (p *colExprParser) nextOp(ops ...string) string {
+	if p.pos >= len(p.allTokens) || p.allTokens[p.pos].kind != tokOp {
+		return ""
+	}
+	for _, op := range ops {
+		if p.allTokens[p.pos].text == op {
+			p.pos++
+			return op
+		}
+	}
+	return ""
+}
+
+func (p *colExprParser) parseSum() (colExpr, error) {
+	lhs, err := p.parseProduct()
+	if err != nil {
+		return nil, err
+	}
+	for op := p.nextOp("+", "-"); op != ""; op = p.nextOp("+", "-") {
+		rhs, err := p.parseProduct()
+		if err != nil {
+			return nil, err
+		}
+		lhs = binaryExpr(op, lhs, rhs)
+	}
+	return lhs, nil
+}
+
+func (p *colExprParser) parseProduct() (colExpr, error) {
+	lhs, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for op := p.nextOp("*", "/"); op != ""; op = p.nextOp("*", "/") {
+		rhs, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		lhs = binaryExpr(op, lhs, rhs)
+	}
+	return lhs, nil
+}
+
+func (p *colExprParser) parseUnary() (colExpr, error) {
+	if p.nextOp("-") == "" {
+		return p.parsePrimary()
+	}
+	operand, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	return func(dR *DataRow) (float64, bool) {
+		val, ok := operand(dR)
+		return -val, ok
+	}, nil
+}
+
+func (p *colExprParser) parsePrimary() (colExpr, error) {
+	if p.pos >= len(p.allTokens) {
+		return nil, fmt.Errorf("Expression ends unexpectedly")
+	}
+	tok := p.allTokens[p.pos]
+	p.pos++
+	switch tok.kind {
+	case tokNumber:
+		val, err := strconv.ParseFloat(tok.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid number %q at position %d", tok.text, tok.pos)
+		}
+		return func(dR *DataRow) (float64, bool) {
+			return val, true
+		}, nil
+	case tokIdent:
+		colIdx, err := p.resolve(tok.text)
+		if err != nil {
+			return nil, err
+		}
+		return func(dR *DataRow) (float64, bool) {
+			return numericValue((*dR)[colIdx])
+		}, nil
+	case tokLParen:
+		inner, err := p.parseSum()
+		if err != nil {
+			return nil, err
+		}
+		if p.pos >= len(p.allTokens) || p.allTokens[p.pos].kind != tokRParen {
+			return nil, fmt.Errorf("Missing ) for ( at position %d", tok.pos)
+		}
+		p.pos++
+		return inner, nil
+	}
+	return nil, fmt.Errorf("Unexpected %q at position %d", tok.text, tok.pos)
+}
+
+// a null operand makes the result null, as does division by zero
+func binaryExpr(op string, lhs colExpr, rhs colExpr) colExpr {
+	return func(dR *DataRow) (float64, bool) {
+		lval, lok := lhs(dR)
+		rval, rok := rhs(dR)
+		if !lok || !rok {
+			return 0, false
+		}
+		switch op {
+		case "+":
+			return lval + rval, true
+		case "-":
+			return lval - rval, true
+		case "*":
+			return lval * rval, true
+		}
+		if rval == 0 {
+			return 0, false
+		}
+		return lval / rval, true
+	}
+}
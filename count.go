@@ -0,0 +1,140 @@
+package repmeta
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	reptext "github.com/radiochild/utils/text"
+	"go.uber.org/zap"
+)
+
+// PageInfo describes the pages of a report, e.g. for "page 3 of 120"
+type PageInfo struct {
+	TotalRows int64
+	PageSize  int // 0 when the report is not paged
+	PageCount int64
+}
+
+func NewPageInfo(totalRows int64, pageSize int) *PageInfo {
+	if pageSize < 0 {
+		pageSize = 0
+	}
+	info := PageInfo{TotalRows: totalRows, PageSize: pageSize}
+	if totalRows > 0 {
+		info.PageCount = 1
+		if pageSize > 0 {
+			info.PageCount = (totalRows + int64(pageSize) - 1) / int64(pageSize)
+		}
+	}
+	return &info
+}
+
+// GroupCount is the number of rows within one value of the top group
+type GroupCount struct {
+	Value string
+	Count int64
+}
+
+// counts cover every page, so a page token is ignored
+func countOptions(opts QueryOptions) QueryOptions {
+	opts.Keyset = false
+	opts.After = ""
+	return opts
+}
+
+// FormatCountQuery renders a query for the number of rows FormatQueryWith
+// would return for spec without paging
+func FormatCountQuery(spec *ReportSpec, opts QueryOptions, logger *zap.SugaredLogger) (string, []interface{}, error) {
+	qp, err := newQueryParts(spec, countOptions(opts))
+	if err != nil {
+		return "", nil, err
+	}
+	table, err := qp.table(spec, spec.FieldColumns())
+	if err != nil {
+		return "", nil, err
+	}
+	where, err := qp.where(spec, logger)
+	if err != nil {
+		return "", nil, err
+	}
+	qry := fmt.Sprintf("select count(*) from %s %s", table, where)
+	return qry, qp.values(), nil
+}
+
+// FormatGroupCountQuery renders a query for the number of rows within each
+// value of the spec's first group, in group order
+func FormatGroupCountQuery(spec *ReportSpec, opts QueryOptions, logger *zap.SugaredLogger) (string, []interface{}, error) {
+	if len(spec.Groups) == 0 {
+		return "", nil, fmt.Errorf("Report has no groups to count")
+	}
+	qp, err := newQueryParts(spec, countOptions(opts))
+	if err != nil {
+		return "", nil, err
+	}
+	if err := checkIdents(&spec.Dataset, "Group", spec.Groups[:1]); err != nil {
+		return "", nil, err
+	}
+	table, err := qp.table(spec, spec.FieldColumns())
+	if err != nil {
+		return "", nil, err
+	}
+	where, err := qp.where(spec, logger)
+	if err != nil {
+		return "", nil, err
+	}
+	group := spec.Dataset.fieldSQL(qp.d, spec.Groups[0])
+	suffix := reptext.AppendText(where, fmt.Sprintf("group by %s", group), formatOrder(qp.d, &spec.Dataset, ascending(spec.Groups[:1])))
+	qry := fmt.Sprintf("select %s, count(*) from %s %s", spec.Dataset.selectSQL(qp.d, spec.Groups[0]), table, suffix)
+	return qry, qp.values(), nil
+}
+
+// CountRows queries db for the number of rows of spec, and the number of
+// pages of pageSize rows they fill
+func CountRows(ctx context.Context, db *sql.DB, spec *ReportSpec, pageSize int, opts QueryOptions, logger *zap.SugaredLogger) (*PageInfo, error) {
+	opts.BindArgs = true
+	qry, args, err := FormatCountQuery(spec, opts, logger)
+	if err != nil {
+		return nil, err
+	}
+	logger.Debugf("Running %s %v", qry, args)
+
+	var totalRows int64
+	if err := db.QueryRowContext(ctx, qry, args...).Scan(&totalRows); err != nil {
+		return nil, err
+	}
+	return NewPageInfo(totalRows, pageSize), nil
+}
+
+// CountGroups queries db for the number of rows within each value of the
+// spec's first group
+func CountGroups(ctx context.Context, db *sql.DB, spec *ReportSpec, opts QueryOptions, logger *zap.SugaredLogger) ([]GroupCount, error) {
+	opts.BindArgs = true
+	qry, args, err := FormatGroupCountQuery(spec, opts, logger)
+	if err != nil {
+		return nil, err
+	}
+	logger.Debugf("Running %s %v", qry, args)
+
+	rows, err := db.QueryContext(ctx, qry, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	_, pFld := spec.Dataset.FieldNamed(spec.Groups[0])
+	allCounts := []GroupCount{}
+	for rows.Next() {
+		var raw interface{}
+		var count int64
+		if err := rows.Scan(&raw, &count); err != nil {
+			return nil, err
+		}
+		value := NewDataValTyped(ToDataValType(pFld.FldType))
+		if err := setScanned(value, raw); err != nil {
+			return nil, err
+		}
+		allCounts = append(allCounts, GroupCount{Value: value.String(), Count: count})
+	}
+	return allCounts, rows.Err()
+}
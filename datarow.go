@@ -12,35 +12,48 @@ func NewDataRow(spec *ReportSpec) (*DataRow, error) {
 	var dbRow DataRow
 	colNames := ColSpecFldNames(spec.Columns)
 	allCols := append(spec.ExtraColumns, colNames...)
-	for _, column := range allCols {
+	for colIdx, column := range allCols {
+		if cs := spec.computedAt(colIdx); cs != nil {
+			dbRow = append(dbRow, NewDataValTyped(cs.computedType()))
+			continue
+		}
 		_, pFld := spec.ColumnNamed(column)
 		if pFld == nil {
 			erx := fmt.Errorf("Unable to scan column named %q", column)
 			return nil, erx
 		}
 		colType := ToDataValType(pFld.FldType)
-		dv := NewDVNone()
-		switch colType {
-		case DVText:
-			dv.ToText()
-		case DVBoolean:
-			dv.ToBool()
-		case DVInt:
-			dv.ToInt()
-		case DVFloat:
-			dv.ToFloat()
-		case DVCurrency:
-			dv.ToCurrency()
-		case DVDate:
-			dv.ToDate()
-		case DVNone:
-		} // switch
-		dbRow = append(dbRow, dv)
+		dbRow = append(dbRow, NewDataValTyped(colType))
 	} // for column
 
 	return &dbRow, nil
 }
 
+// NewDatasetRow holds a value for every field of ds, in order
+func NewDatasetRow(ds *DatasetSpec) *DataRow {
+	var dsRow DataRow
+	for _, fld := range ds.Fields {
+		dsRow = append(dsRow, NewDataValTyped(ToDataValType(fld.FldType)))
+	}
+	return &dsRow
+}
+
+// ProjectRow copies the values of a dataset row (see NewDatasetRow) into
+// the columns of the report row dR
+func (spec *ReportSpec) ProjectRow(dsRow *DataRow, dR *DataRow) error {
+	for colIdx, column := range spec.AllColumns() {
+		if spec.computedAt(colIdx) != nil {
+			continue
+		}
+		fldIdx, _ := spec.Dataset.FieldNamed(column)
+		if fldIdx == -1 {
+			return fmt.Errorf("Unable to project column named %q", column)
+		}
+		(*dR)[colIdx].CopyFrom((*dsRow)[fldIdx])
+	}
+	return nil
+}
+
 // reset all values in the row
 func (dR *DataRow) ResetNumerics() {
 	for _, ptr := range *dR {
@@ -63,6 +76,18 @@ func (dR DataRow) ValueAtIndex(fldIdx int) string {
 	return dR[fldIdx].String()
 }
 
+// ScanPointers are the pointers of the columns of dR a query selects,
+// every column that is not computed
+func (spec *ReportSpec) ScanPointers(dR *DataRow) []interface{} {
+	var allPtrs []interface{}
+	for colIdx, dv := range *dR {
+		if spec.computedAt(colIdx) == nil {
+			allPtrs = append(allPtrs, dv.GetPointer())
+		}
+	}
+	return allPtrs
+}
+
 func (dR DataRow) GetPointers() []interface{} {
 	var allPtrs []interface{}
 	for _, ptr := range dR {
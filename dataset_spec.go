@@ -9,6 +9,9 @@ type DatasetSpec struct {
 	DatasetName string
 	DatasetDesc string
 	ViewName    string
+	Alias       string // qualifies the ViewName's columns when there are Joins
+	Joins       []JoinSpec
+	Dialect     string
 	Fields      []FieldSpec
 }
 
@@ -30,3 +33,24 @@ func (ds *DatasetSpec) FieldNamed(name string) (int, *FieldSpec) {
 	}
 	return -1, nil
 }
+
+// SQLDialect returns the Dialect named by ds.Dialect, PostgreSQL by default
+func (ds *DatasetSpec) SQLDialect() (Dialect, error) {
+	return DialectNamed(ds.Dialect)
+}
+
+// FieldForHeader finds the field a file column refers to, by FldName or
+// else by its ColName ignoring case
+func (ds *DatasetSpec) FieldForHeader(header string) (int, *FieldSpec) {
+	name := strings.TrimSpace(header)
+	idx, pFld := ds.FieldNamed(name)
+	if idx >= 0 {
+		return idx, pFld
+	}
+	for idx, fld := range ds.Fields {
+		if strings.EqualFold(fld.ColName, name) {
+			return idx, &fld
+		}
+	}
+	return -1, nil
+}
@@ -0,0 +1,130 @@
+package repmeta
+
+import (
+	"context"
+	"database/sql"
+
+	"go.uber.org/zap"
+)
+
+// RowIterator steps through the rows of a report. The DataRow returned
+// by Row may be reused, and is only valid until the next call to Next.
+type RowIterator interface {
+	Next() bool
+	Row() *DataRow
+	Err() error
+	Close() error
+}
+
+// DataSource provides the rows of a ReportSpec, in the order of its Groups
+// and Sorts
+type DataSource interface {
+	Rows(ctx context.Context, spec *ReportSpec, logger *zap.SugaredLogger) (RowIterator, error)
+}
+
+// SQLSource reads the rows of a ReportSpec from a database/sql query
+type SQLSource struct {
+	DB      *sql.DB
+	Options QueryOptions
+}
+
+func (src *SQLSource) Rows(ctx context.Context, spec *ReportSpec, logger *zap.SugaredLogger) (RowIterator, error) {
+	dR, err := NewDataRow(spec)
+	if err != nil {
+		return nil, err
+	}
+	opts := src.Options
+	opts.BindArgs = true
+	qry, args, err := FormatQueryWith(spec, -1, opts, logger)
+	if err != nil {
+		return nil, err
+	}
+	logger.Debugf("Running %s %v", qry, args)
+
+	rows, err := src.DB.QueryContext(ctx, qry, args...)
+	if err != nil {
+		return nil, err
+	}
+	return newSQLRows(rows, spec, dR), nil
+}
+
+// sqlRows scans every row into the same DataRow
+type sqlRows struct {
+	rows *sql.Rows
+	dR   *DataRow
+	ptrs []interface{}
+	err  error
+}
+
+func newSQLRows(rows *sql.Rows, spec *ReportSpec, dR *DataRow) *sqlRows {
+	return &sqlRows{rows: rows, dR: dR, ptrs: spec.ScanPointers(dR)}
+}
+
+func (it *sqlRows) Next() bool {
+	if it.err != nil || !it.rows.Next() {
+		return false
+	}
+	it.err = it.rows.Scan(it.ptrs...)
+	return it.err == nil
+}
+
+func (it *sqlRows) Row() *DataRow {
+	return it.dR
+}
+
+func (it *sqlRows) Err() error {
+	if it.err != nil {
+		return it.err
+	}
+	return it.rows.Err()
+}
+
+func (it *sqlRows) Close() error {
+	return it.rows.Close()
+}
+
+// sliceRows iterates rows already held in memory
+type sliceRows struct {
+	rows []*DataRow
+	idx  int
+}
+
+func newSliceRows(rows []*DataRow) *sliceRows {
+	return &sliceRows{rows: rows, idx: -1}
+}
+
+func (it *sliceRows) Next() bool {
+	if it.idx+1 >= len(it.rows) {
+		return false
+	}
+	it.idx++
+	return true
+}
+
+func (it *sliceRows) Row() *DataRow {
+	return it.rows[it.idx]
+}
+
+func (it *sliceRows) Err() error {
+	return nil
+}
+
+func (it *sliceRows) Close() error {
+	return nil
+}
+
+// SortRows puts rows in the order a query would return them, by the
+// spec's Groups and then its Sorts, with nulls placed as the dataset's
+// dialect places them. Fields that are not columns of the report are
+// skipped.
+func SortRows(spec *ReportSpec, rows []*DataRow) {
+	d := spec.sortDialect()
+	allKeys := []sortKey{}
+	for _, ss := range spec.OrderSpecs() {
+		colIdx, _ := spec.ColumnNamed(ss.FldName)
+		if colIdx >= 0 {
+			allKeys = append(allKeys, newSortKey(colIdx, ss, d))
+		}
+	}
+	sortRows(rows, allKeys)
+}
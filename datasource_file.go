@@ -0,0 +1,227 @@
+package repmeta
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"go.uber.org/zap"
+)
+
+// CSVSource reads report rows from a CSV file. The header row names the
+// dataset field of each column, by FldName or ColName, and empty cells
+// are null. The spec's Filters and FilterTree are applied as rows are
+// read, and the rows are sorted by its Groups and Sorts.
+type CSVSource struct {
+	Filename string
+	Reader   io.Reader   // read instead of Filename when set
+	Dates    DateContext // resolves relative date filters
+}
+
+// JSONLinesSource reads report rows from a file holding one JSON object
+// per line, keyed by FldName or ColName. The spec's Filters and FilterTree
+// are applied as rows are read, and the rows are sorted by its Groups
+// and Sorts.
+type JSONLinesSource struct {
+	Filename string
+	Reader   io.Reader   // read instead of Filename when set
+	Dates    DateContext // resolves relative date filters
+}
+
+func openSource(filename string, rdr io.Reader) (io.Reader, func(), error) {
+	if rdr != nil {
+		return rdr, func() {}, nil
+	}
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	return file, func() { file.Close() }, nil
+}
+
+// sourceName is used in error messages
+func sourceName(filename string, rdr io.Reader) string {
+	if rdr != nil || len(filename) == 0 {
+		return "input"
+	}
+	return filename
+}
+
+// fileRows collects the rows of a file that pass the spec's filters.
+// They are kept as dataset rows until sorted, as a sort field need not
+// be a column.
+type fileRows struct {
+	spec    *ReportSpec
+	pred    RowPredicate
+	allRows []*DataRow
+}
+
+func newFileRows(spec *ReportSpec, dc DateContext) (*fileRows, error) {
+	pred, err := spec.FilterPredicateAt(dc)
+	if err != nil {
+		return nil, err
+	}
+	return &fileRows{spec: spec, pred: pred}, nil
+}
+
+// add keeps dsRow, a row of every dataset field, if it passes the filters
+func (fr *fileRows) add(dsRow *DataRow) {
+	if fr.pred != nil && !fr.pred(dsRow) {
+		return
+	}
+	fr.allRows = append(fr.allRows, dsRow)
+}
+
+// iterator sorts the rows and projects them onto the report's columns
+func (fr *fileRows) iterator() (RowIterator, error) {
+	sortRows(fr.allRows, datasetSortKeys(fr.spec))
+	allRows := []*DataRow{}
+	for _, dsRow := range fr.allRows {
+		dR, err := NewDataRow(fr.spec)
+		if err != nil {
+			return nil, err
+		}
+		if err := fr.spec.ProjectRow(dsRow, dR); err != nil {
+			return nil, err
+		}
+		allRows = append(allRows, dR)
+	}
+	return newSliceRows(allRows), nil
+}
+
+// requiredFields lists the fields a file must provide: every column
+// of the report, every sort field and every filtered field, flat or
+// nested
+func requiredFields(spec *ReportSpec) []string {
+	allFlds := spec.FieldColumns()
+	allFlds = append(allFlds, sortFldNames(spec.Sorts)...)
+	return append(allFlds, spec.rootFilterGroup().FldNames()...)
+}
+
+func (src *CSVSource) Rows(ctx context.Context, spec *ReportSpec, logger *zap.SugaredLogger) (RowIterator, error) {
+	rdr, closer, err := openSource(src.Filename, src.Reader)
+	if err != nil {
+		return nil, err
+	}
+	defer closer()
+	name := sourceName(src.Filename, src.Reader)
+
+	fr, err := newFileRows(spec, src.Dates)
+	if err != nil {
+		return nil, err
+	}
+
+	cr := csv.NewReader(rdr)
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read header of %s: %s", name, err.Error())
+	}
+	// dataset field index of each record column
+	var fldIdxs []int
+	present := map[string]bool{}
+	for _, colName := range header {
+		fldIdx, pFld := spec.Dataset.FieldForHeader(colName)
+		if pFld != nil {
+			present[pFld.FldName] = true
+		}
+		fldIdxs = append(fldIdxs, fldIdx)
+	}
+	for _, fldName := range requiredFields(spec) {
+		if !present[fldName] {
+			return nil, fmt.Errorf("Field %q not found in %s", fldName, name)
+		}
+	}
+
+	for lineNum := 2; ; lineNum++ {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		dsRow := NewDatasetRow(&spec.Dataset)
+		for recIdx, fldIdx := range fldIdxs {
+			if fldIdx == -1 || len(rec[recIdx]) == 0 {
+				continue
+			}
+			if err := (*dsRow)[fldIdx].SetValue(rec[recIdx]); err != nil {
+				return nil, fmt.Errorf("%s line %d, column %q: %s", name, lineNum, header[recIdx], err.Error())
+			}
+		}
+		fr.add(dsRow)
+	}
+	logger.Debugf("Read %d rows from %s", len(fr.allRows), name)
+
+	return fr.iterator()
+}
+
+func (src *JSONLinesSource) Rows(ctx context.Context, spec *ReportSpec, logger *zap.SugaredLogger) (RowIterator, error) {
+	rdr, closer, err := openSource(src.Filename, src.Reader)
+	if err != nil {
+		return nil, err
+	}
+	defer closer()
+	name := sourceName(src.Filename, src.Reader)
+
+	fr, err := newFileRows(spec, src.Dates)
+	if err != nil {
+		return nil, err
+	}
+
+	scanner := bufio.NewScanner(rdr)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		dsRow, err := jsonLineRow(&spec.Dataset, line)
+		if err != nil {
+			return nil, fmt.Errorf("%s line %d: %s", name, lineNum, err.Error())
+		}
+		fr.add(dsRow)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	logger.Debugf("Read %d rows from %s", len(fr.allRows), name)
+
+	return fr.iterator()
+}
+
+// fields missing from the line are null
+func jsonLineRow(ds *DatasetSpec, line []byte) (*DataRow, error) {
+	var obj map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(line))
+	decoder.UseNumber()
+	if err := decoder.Decode(&obj); err != nil {
+		return nil, err
+	}
+
+	dsRow := NewDatasetRow(ds)
+	for key, val := range obj {
+		fldIdx, _ := ds.FieldForHeader(key)
+		if fldIdx == -1 {
+			continue
+		}
+		if num, ok := val.(json.Number); ok {
+			val = num.String()
+		}
+		if err := (*dsRow)[fldIdx].SetValue(val); err != nil {
+			return nil, fmt.Errorf("Field %q: %s", key, err.Error())
+		}
+	}
+	return dsRow, nil
+}
@@ -3,6 +3,9 @@ package repmeta
 import (
   "database/sql"
 	"fmt"
+	"math"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -15,6 +18,8 @@ const (
 	DVText
 	DVInt
 	DVFloat
+	// currency is held in pennies: in the database, in files read by a
+	// DataSource, in filter values and in totals. Only String shows dollars.
 	DVCurrency
 	DVBoolean
 	DVDate
@@ -31,11 +36,12 @@ func NewDVNone() *DataVal {
 }
 
 func NewDVBoolean(v ...bool) *DataVal {
-	var val bool
+	nullBool := sql.NullBool{}
 	if len(v) > 0 {
-		val = v[0]
+		nullBool.Bool = v[0]
+		nullBool.Valid = true
 	}
-	dv := DataVal{Typ: DVBoolean, Ptr: &val}
+	dv := DataVal{Typ: DVBoolean, Ptr: &nullBool}
 	return &dv
 }
 
@@ -50,29 +56,32 @@ func NewDVText(v ...string) *DataVal {
 }
 
 func NewDVInt(v ...int64) *DataVal {
-	var val int64
+	nullInt := sql.NullInt64{}
 	if len(v) > 0 {
-		val = v[0]
+		nullInt.Int64 = v[0]
+		nullInt.Valid = true
 	}
-	dv := DataVal{Typ: DVInt, Ptr: &val}
+	dv := DataVal{Typ: DVInt, Ptr: &nullInt}
 	return &dv
 }
 
 func NewDVCurrency(v ...int64) *DataVal {
-	var val int64
+	nullInt := sql.NullInt64{}
 	if len(v) > 0 {
-		val = v[0]
+		nullInt.Int64 = v[0]
+		nullInt.Valid = true
 	}
-	dv := DataVal{Typ: DVInt, Ptr: &val}
+	dv := DataVal{Typ: DVCurrency, Ptr: &nullInt}
 	return &dv
 }
 
 func NewDVFloat(v ...float64) *DataVal {
-	var val float64
+	nullFloat := sql.NullFloat64{}
 	if len(v) > 0 {
-		val = v[0]
+		nullFloat.Float64 = v[0]
+		nullFloat.Valid = true
 	}
-	dv := DataVal{Typ: DVFloat, Ptr: &val}
+	dv := DataVal{Typ: DVFloat, Ptr: &nullFloat}
 	return &dv
 }
 
@@ -86,6 +95,13 @@ func NewDVDate(v ...time.Time) *DataVal {
 	return &dv
 }
 
+// NewDataValTyped returns a null value of type typ
+func NewDataValTyped(typ DataValType) *DataVal {
+	dv := DataVal{Typ: typ}
+	dv.ResetAll()
+	return &dv
+}
+
 func (dv *DataVal) ResetAll() {
 	switch dv.Typ {
 	case DVNone:
@@ -142,44 +158,49 @@ func (dv *DataVal) ToText(v ...*string) {
   dv.Ptr = &nullStr
 }
 
+// Numeric and boolean values are null until set or scanned
 func (dv *DataVal) ToInt(v ...*int64) {
 	dv.ToNone()
 	dv.Typ = DVInt
-	if len(v) == 0 {
-		dv.Ptr = new(int64)
-	} else {
-		dv.Ptr = v[0]
+	nullInt := sql.NullInt64{}
+	if len(v) > 0 {
+		nullInt.Valid = true
+		nullInt.Int64 = *v[0]
 	}
+	dv.Ptr = &nullInt
 }
 
 func (dv *DataVal) ToCurrency(v ...*int64) {
 	dv.ToNone()
 	dv.Typ = DVCurrency
-	if len(v) == 0 {
-		dv.Ptr = new(int64)
-	} else {
-		dv.Ptr = v[0]
+	nullInt := sql.NullInt64{}
+	if len(v) > 0 {
+		nullInt.Valid = true
+		nullInt.Int64 = *v[0]
 	}
+	dv.Ptr = &nullInt
 }
 
 func (dv *DataVal) ToFloat(v ...*float64) {
 	dv.ToNone()
 	dv.Typ = DVFloat
-	if len(v) == 0 {
-		dv.Ptr = new(float64)
-	} else {
-		dv.Ptr = v[0]
+	nullFloat := sql.NullFloat64{}
+	if len(v) > 0 {
+		nullFloat.Valid = true
+		nullFloat.Float64 = *v[0]
 	}
+	dv.Ptr = &nullFloat
 }
 
 func (dv *DataVal) ToBool(v ...*bool) {
 	dv.ToNone()
 	dv.Typ = DVBoolean
-	if len(v) == 0 {
-		dv.Ptr = new(bool)
-	} else {
-		dv.Ptr = v[0]
+	nullBool := sql.NullBool{}
+	if len(v) > 0 {
+		nullBool.Valid = true
+		nullBool.Bool = *v[0]
 	}
+	dv.Ptr = &nullBool
 }
 
 func (dv *DataVal) ToDate(v ...*time.Time) {
@@ -194,7 +215,11 @@ func (dv *DataVal) ToDate(v ...*time.Time) {
   dv.Ptr = &nullStr
 }
 
+// Null values are rendered as blanks
 func (dv *DataVal) String() string {
+	if dv.IsNull() {
+		return ""
+	}
 	switch dv.Typ {
 	case DVNone:
 		return ""
@@ -205,7 +230,7 @@ func (dv *DataVal) String() string {
     }
     return ""
 	case DVCurrency:
-		pennies := *dv.Ptr.(*int64)
+		pennies := dv.Ptr.(*sql.NullInt64).Int64
 		dollars := pennies / 100
 		cents := pennies % 100
 		return fmt.Sprintf("%d.%2.2d", dollars, cents)
@@ -216,11 +241,11 @@ func (dv *DataVal) String() string {
     }
     return ""
 	case DVInt:
-		return fmt.Sprintf("%d", *dv.Ptr.(*int64))
+		return fmt.Sprintf("%d", dv.Ptr.(*sql.NullInt64).Int64)
 	case DVFloat:
-		return fmt.Sprintf("%.2f", *dv.Ptr.(*float64))
+		return fmt.Sprintf("%.2f", dv.Ptr.(*sql.NullFloat64).Float64)
 	case DVBoolean:
-		return fmt.Sprintf("%t", *dv.Ptr.(*bool))
+		return fmt.Sprintf("%t", dv.Ptr.(*sql.NullBool).Bool)
 	}
 	return ""
 }
@@ -243,6 +268,26 @@ func ToDataValType(s string) DataValType {
 	return DVNone
 }
 
+// fldTypeName is the FldType holding values of typ, the inverse of
+// ToDataValType
+func fldTypeName(typ DataValType) string {
+	switch typ {
+	case DVText:
+		return "text"
+	case DVInt:
+		return "int"
+	case DVDate:
+		return "date"
+	case DVCurrency:
+		return "currency"
+	case DVFloat:
+		return "float"
+	case DVBoolean:
+		return "boolean"
+	}
+	return ""
+}
+
 func (dv *DataVal) FromDataVal() string {
 	switch dv.Typ {
 	case DVNone:
@@ -267,6 +312,7 @@ func (dv *DataVal) GetPointer() DataValPtr {
 	return dv.Ptr
 }
 
+// Null values are skipped, and a null sum takes the first non-null value
 func (dv *DataVal) DidAccumulate(other *DataVal) bool {
 	thisType := dv.Typ
 	if thisType != other.Typ {
@@ -275,15 +321,258 @@ func (dv *DataVal) DidAccumulate(other *DataVal) bool {
 	didAccumulate := true
 	switch thisType {
 	case DVInt, DVCurrency:
-		thisVal := *dv.Ptr.(*int64)
-		otherVal := *other.Ptr.(*int64)
-		*dv.Ptr.(*int64) = thisVal + otherVal
+		otherVal, ok := other.Int64()
+		if ok {
+			thisVal, _ := dv.Int64()
+			dv.SetInt64(thisVal + otherVal)
+		}
 	case DVFloat:
-		thisVal := *dv.Ptr.(*float64)
-		otherVal := *other.Ptr.(*float64)
-		*dv.Ptr.(*float64) = thisVal + otherVal
+		otherVal, ok := other.Float64()
+		if ok {
+			thisVal, _ := dv.Float64()
+			dv.SetFloat64(thisVal + otherVal)
+		}
 	default:
 		didAccumulate = false
 	}
 	return didAccumulate
 }
+
+// Int64 returns the value of a DVInt or DVCurrency, and whether it is non-null
+func (dv *DataVal) Int64() (int64, bool) {
+	nullInt, ok := dv.Ptr.(*sql.NullInt64)
+	if !ok {
+		return 0, false
+	}
+	return nullInt.Int64, nullInt.Valid
+}
+
+// Float64 returns the value of a DVFloat, and whether it is non-null
+func (dv *DataVal) Float64() (float64, bool) {
+	nullFloat, ok := dv.Ptr.(*sql.NullFloat64)
+	if !ok {
+		return 0, false
+	}
+	return nullFloat.Float64, nullFloat.Valid
+}
+
+// Bool returns the value of a DVBoolean, and whether it is non-null
+func (dv *DataVal) Bool() (bool, bool) {
+	nullBool, ok := dv.Ptr.(*sql.NullBool)
+	if !ok {
+		return false, false
+	}
+	return nullBool.Bool, nullBool.Valid
+}
+
+func (dv *DataVal) SetInt64(v int64) {
+	*dv.Ptr.(*sql.NullInt64) = sql.NullInt64{Int64: v, Valid: true}
+}
+
+func (dv *DataVal) SetFloat64(v float64) {
+	*dv.Ptr.(*sql.NullFloat64) = sql.NullFloat64{Float64: v, Valid: true}
+}
+
+func (dv *DataVal) SetBool(v bool) {
+	*dv.Ptr.(*sql.NullBool) = sql.NullBool{Bool: v, Valid: true}
+}
+
+// DVNone and values scanned from a NULL are null
+func (dv *DataVal) IsNull() bool {
+	switch dv.Typ {
+	case DVNone:
+		return true
+	case DVText, DVDate:
+		return !dv.Ptr.(*sql.NullString).Valid
+	case DVInt, DVCurrency:
+		return !dv.Ptr.(*sql.NullInt64).Valid
+	case DVFloat:
+		return !dv.Ptr.(*sql.NullFloat64).Valid
+	case DVBoolean:
+		return !dv.Ptr.(*sql.NullBool).Valid
+	}
+	return false
+}
+
+// Compare orders two values of the same type, returning -1, 0 or 1.
+// Nulls sort before all other values.
+func (dv *DataVal) Compare(other *DataVal) int {
+	thisNull := dv.IsNull()
+	otherNull := other.IsNull()
+	if thisNull || otherNull {
+		return compareBools(!thisNull, !otherNull)
+	}
+	switch dv.Typ {
+	case DVInt, DVCurrency:
+		thisVal, _ := dv.Int64()
+		otherVal, _ := other.Int64()
+		return compareInts(thisVal, otherVal)
+	case DVFloat:
+		thisVal, _ := dv.Float64()
+		otherVal, _ := other.Float64()
+		return compareFloats(thisVal, otherVal)
+	case DVBoolean:
+		thisVal, _ := dv.Bool()
+		otherVal, _ := other.Bool()
+		return compareBools(thisVal, otherVal)
+	}
+	return strings.Compare(dv.String(), other.String())
+}
+
+// CopyFrom replaces the value of dv with a copy of other's
+func (dv *DataVal) CopyFrom(other *DataVal) {
+	dv.Typ = other.Typ
+	switch other.Typ {
+	case DVNone:
+		dv.Ptr = nil
+	case DVText, DVDate:
+		nullStr := *other.Ptr.(*sql.NullString)
+		dv.Ptr = &nullStr
+	case DVInt, DVCurrency:
+		nullInt := *other.Ptr.(*sql.NullInt64)
+		dv.Ptr = &nullInt
+	case DVFloat:
+		nullFloat := *other.Ptr.(*sql.NullFloat64)
+		dv.Ptr = &nullFloat
+	case DVBoolean:
+		nullBool := *other.Ptr.(*sql.NullBool)
+		dv.Ptr = &nullBool
+	}
+}
+
+func compareInts(a, b int64) int {
+	if a < b {
+		return -1
+	}
+	if a > b {
+		return 1
+	}
+	return 0
+}
+
+func compareFloats(a, b float64) int {
+	if a < b {
+		return -1
+	}
+	if a > b {
+		return 1
+	}
+	return 0
+}
+
+func compareBools(a, b bool) int {
+	if a == b {
+		return 0
+	}
+	if b {
+		return -1
+	}
+	return 1
+}
+
+// SetValue assigns a Go value to dv, converting it to dv's type. A nil v
+// makes dv null. A DVCurrency is given in pennies, whatever the type of v,
+// and like a DVInt it cannot be given a fraction.
+func (dv *DataVal) SetValue(v interface{}) error {
+	dv.ResetAll()
+	if v == nil || dv.Typ == DVNone {
+		return nil
+	}
+	switch val := v.(type) {
+	case int:
+		return dv.setInt64(int64(val))
+	case int16:
+		return dv.setInt64(int64(val))
+	case int32:
+		return dv.setInt64(int64(val))
+	case int64:
+		return dv.setInt64(val)
+	case float32:
+		return dv.setFloat64(float64(val))
+	case float64:
+		return dv.setFloat64(val)
+	case bool:
+		if dv.Typ != DVBoolean {
+			return fmt.Errorf("Unable to assign %t to %s", val, dv.FromDataVal())
+		}
+		dv.SetBool(val)
+		return nil
+	case time.Time:
+		return dv.setText(val.Format("2006-01-02"))
+	case string:
+		return dv.setText(val)
+	}
+	return fmt.Errorf("Unable to assign %T to %s", v, dv.FromDataVal())
+}
+
+func (dv *DataVal) setInt64(v int64) error {
+	switch dv.Typ {
+	case DVInt, DVCurrency:
+		dv.SetInt64(v)
+	case DVFloat:
+		dv.SetFloat64(float64(v))
+	case DVText:
+		return dv.setText(fmt.Sprintf("%d", v))
+	default:
+		return fmt.Errorf("Unable to assign %d to %s", v, dv.FromDataVal())
+	}
+	return nil
+}
+
+func (dv *DataVal) setFloat64(v float64) error {
+	switch dv.Typ {
+	case DVFloat:
+		dv.SetFloat64(v)
+	case DVInt, DVCurrency:
+		if v != math.Trunc(v) {
+			return fmt.Errorf("Unable to assign %g to %s", v, dv.FromDataVal())
+		}
+		dv.SetInt64(int64(v))
+	case DVText:
+		return dv.setText(strconv.FormatFloat(v, 'f', -1, 64))
+	default:
+		return fmt.Errorf("Unable to assign %g to %s", v, dv.FromDataVal())
+	}
+	return nil
+}
+
+// text is parsed according to dv's type
+func (dv *DataVal) setText(s string) error {
+	switch dv.Typ {
+	case DVText:
+		*dv.Ptr.(*sql.NullString) = sql.NullString{String: s, Valid: true}
+		return nil
+	case DVDate:
+		// keep just the date of a timestamp
+		if len(s) > 10 {
+			s = s[:10]
+		}
+		if _, err := time.Parse("2006-01-02", s); err != nil {
+			return fmt.Errorf("Value %q is not a valid date", s)
+		}
+		*dv.Ptr.(*sql.NullString) = sql.NullString{String: s, Valid: true}
+		return nil
+	case DVInt, DVCurrency:
+		val, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+		if err != nil {
+			return fmt.Errorf("Value %q is not a valid %s", s, fldTypeName(dv.Typ))
+		}
+		dv.SetInt64(val)
+		return nil
+	case DVFloat:
+		val, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+		if err != nil {
+			return fmt.Errorf("Value %q is not a valid %s", s, dv.FromDataVal())
+		}
+		dv.SetFloat64(val)
+		return nil
+	case DVBoolean:
+		val, err := strconv.ParseBool(strings.TrimSpace(s))
+		if err != nil {
+			return fmt.Errorf("Value %q is not a valid boolean", s)
+		}
+		dv.SetBool(val)
+		return nil
+	}
+	return fmt.Errorf("Unable to assign %q to %s", s, dv.FromDataVal())
+}
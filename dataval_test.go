@@ -0,0 +1,63 @@
+package repmeta
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSetCurrency(t *testing.T) {
+	for _, tc := range []struct {
+		v    interface{}
+		want int64
+		err  string
+	}{
+		{"1234", 1234, ""},
+		{" -50 ", -50, ""},
+		{int64(700), 700, ""},
+		{float64(1200), 1200, ""},
+		{"12.34", 0, `Value "12.34" is not a valid currency`},
+		{"12.00", 0, `Value "12.00" is not a valid currency`},
+		{12.34, 0, "Unable to assign 12.34"},
+	} {
+		dv := NewDVCurrency()
+		err := dv.SetValue(tc.v)
+		switch {
+		case len(tc.err) > 0:
+			if err == nil || !strings.HasPrefix(err.Error(), tc.err) {
+				t.Errorf("%v: %v, expected %q", tc.v, err, tc.err)
+			}
+		case err != nil:
+			t.Errorf("%v: %s", tc.v, err)
+		default:
+			if got, _ := dv.Int64(); got != tc.want {
+				t.Errorf("%v: %d, expected %d", tc.v, got, tc.want)
+			}
+		}
+	}
+}
+
+// a database average of currency holds a fraction of a penny
+func TestSetScannedCurrency(t *testing.T) {
+	for _, tc := range []struct {
+		raw  interface{}
+		want int64
+	}{
+		{[]byte("3500"), 3500},
+		{[]byte("1166.6666666666666667"), 1167},
+		{"-12.5", -13},
+		{float64(12.5), 13},
+		{int64(42), 42},
+	} {
+		dv := NewDVCurrency()
+		if err := setScanned(dv, tc.raw); err != nil {
+			t.Errorf("%v: %s", tc.raw, err)
+			continue
+		}
+		if got, _ := dv.Int64(); got != tc.want {
+			t.Errorf("%v: %d, expected %d", tc.raw, got, tc.want)
+		}
+	}
+	if err := setScanned(NewDVCurrency(), []byte("n/a")); err == nil {
+		t.Error("Scanned currency \"n/a\" was accepted")
+	}
+}
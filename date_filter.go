@@ -0,0 +1,158 @@
+package repmeta
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Relative date FilterSpec.Op values, for fields with FldType "date".
+// Each is resolved to a "range" between two dates when the filter is
+// rendered, so a saved report always covers the current period.
+// Weeks start on Monday.
+const (
+	DateToday         = "today"
+	DateYesterday     = "yesterday"
+	DateLastNDays     = "last_n_days" // Values[0] days ending today
+	DateThisWeek      = "this_week"   // Monday through Sunday
+	DateThisMonth     = "this_month"  // the whole calendar month
+	DateLastMonth     = "last_month"  // the whole previous month
+	DateQuarterToDate = "quarter_to_date"
+	DateYearToDate    = "year_to_date"
+	DateLastNMonths   = "last_n_months" // Values[0] months, this month included, ending today
+)
+
+func RelativeDateOps() []string {
+	return []string{DateToday, DateYesterday, DateLastNDays, DateThisWeek, DateThisMonth,
+		DateLastMonth, DateQuarterToDate, DateYearToDate, DateLastNMonths}
+}
+
+func IsRelativeDateOp(op string) bool {
+	for _, dateOp := range RelativeDateOps() {
+		if op == dateOp {
+			return true
+		}
+	}
+	return false
+}
+
+// DateContext is the clock and timezone relative dates are resolved with
+type DateContext struct {
+	Now      func() time.Time // time.Now when nil
+	Location *time.Location   // time.Local when nil
+}
+
+// Today is midnight of the current date in dc's Location
+func (dc DateContext) Today() time.Time {
+	now := time.Now
+	if dc.Now != nil {
+		now = dc.Now
+	}
+	loc := time.Local
+	if dc.Location != nil {
+		loc = dc.Location
+	}
+	y, m, d := now().In(loc).Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, loc)
+}
+
+// DateRange returns the first and last dates covered by a relative date op
+func (dc DateContext) DateRange(op string, values []string) (time.Time, time.Time, error) {
+	today := dc.Today()
+	y, m, _ := today.Date()
+	loc := today.Location()
+	switch op {
+	case DateToday:
+		return today, today, nil
+	case DateYesterday:
+		yesterday := today.AddDate(0, 0, -1)
+		return yesterday, yesterday, nil
+	case DateLastNDays:
+		numDays, err := relativeCount(op, values)
+		if err != nil {
+			return today, today, err
+		}
+		return today.AddDate(0, 0, 1-numDays), today, nil
+	case DateThisWeek:
+		// Sunday is day 0
+		daysSinceMonday := (int(today.Weekday()) + 6) % 7
+		monday := today.AddDate(0, 0, -daysSinceMonday)
+		return monday, monday.AddDate(0, 0, 6), nil
+	case DateThisMonth:
+		first := time.Date(y, m, 1, 0, 0, 0, 0, loc)
+		return first, first.AddDate(0, 1, -1), nil
+	case DateLastMonth:
+		first := time.Date(y, m-1, 1, 0, 0, 0, 0, loc)
+		return first, first.AddDate(0, 1, -1), nil
+	case DateQuarterToDate:
+		firstMonth := m - (m-1)%3
+		return time.Date(y, firstMonth, 1, 0, 0, 0, 0, loc), today, nil
+	case DateYearToDate:
+		return time.Date(y, time.January, 1, 0, 0, 0, 0, loc), today, nil
+	case DateLastNMonths:
+		numMonths, err := relativeCount(op, values)
+		if err != nil {
+			return today, today, err
+		}
+		return time.Date(y, m+1-time.Month(numMonths), 1, 0, 0, 0, 0, loc), today, nil
+	}
+	return today, today, fmt.Errorf("Unknown relative date op %q", op)
+}
+
+func relativeCount(op string, values []string) (int, error) {
+	if len(values) != 1 {
+		return 0, fmt.Errorf("Op %q expects a single count, %d values provided", op, len(values))
+	}
+	count, err := strconv.Atoi(strings.TrimSpace(values[0]))
+	if err != nil || count < 1 {
+		return 0, fmt.Errorf("Op %q expects a count of at least 1, not %q", op, values[0])
+	}
+	return count, nil
+}
+
+// ResolveDates returns fs with a relative date op replaced by the "range"
+// it covers today. Other filters are returned unchanged.
+func (fs FilterSpec) ResolveDates(ds *DatasetSpec, dc DateContext) (FilterSpec, error) {
+	if !IsRelativeDateOp(fs.Op) {
+		return fs, nil
+	}
+	if ds == nil {
+		return fs, fmt.Errorf("Dataset not provided for filter named %q", fs.FldName)
+	}
+	idx, pFld := ds.FieldNamed(fs.FldName)
+	if idx == -1 {
+		return fs, fmt.Errorf("Filter field named %q not found in dataset %q", fs.FldName, ds.DatasetName)
+	}
+	if pFld.FldType != "date" {
+		return fs, fmt.Errorf("Op %q requires a date field, %q is %s", fs.Op, fs.FldName, pFld.FldType)
+	}
+	first, last, err := dc.DateRange(fs.Op, fs.Values)
+	if err != nil {
+		return fs, fmt.Errorf("Filter field named %q: %s", fs.FldName, err.Error())
+	}
+	resolved := FilterSpec{
+		FldName: fs.FldName,
+		Op:      "range",
+		Values:  []string{first.Format("2006-01-02"), last.Format("2006-01-02")},
+		Options: fs.Options,
+	}
+	return resolved, nil
+}
+
+// ResolveDates returns a copy of the group with every relative date
+// filter resolved. Filters that cannot be resolved are left as they are,
+// for WhereTerm or Predicate to report.
+func (fg *FilterGroup) ResolveDates(ds *DatasetSpec, dc DateContext) *FilterGroup {
+	resolved := FilterGroup{Op: fg.Op}
+	for _, filter := range fg.Filters {
+		if rFilter, err := filter.ResolveDates(ds, dc); err == nil {
+			filter = rFilter
+		}
+		resolved.Filters = append(resolved.Filters, filter)
+	}
+	for idx := range fg.Groups {
+		resolved.Groups = append(resolved.Groups, *fg.Groups[idx].ResolveDates(ds, dc))
+	}
+	return &resolved
+}
@@ -0,0 +1,333 @@
+package repmeta
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Dialect captures the SQL differences between the databases a
+// ReportSpec can be run against
+type Dialect interface {
+	Name() string
+	QuoteIdent(name string) string
+	QuoteLiteral(s string) string
+	Placeholder(pos int) string
+	BindValue(v interface{}) interface{}
+	OpCodeSQL(op string, shouldNegate bool) (string, string)
+	// wraps both sides of a like comparison when the dialect has no ilike
+	FoldCase(expr string) string
+	// returns the text following "select" and the text ending the query
+	Paging(maxRecs int, startRec int, isOrdered bool) (string, string)
+	// returns the group by clause totalling every prefix of the quoted
+	// columns, "" when the dialect has no rollup
+	GroupByRollup(cols []string) string
+	// returns the order by term for the quoted column, nulls is "first",
+	// "last" or "" for the database's own placement
+	OrderTerm(col string, isDesc bool, nulls string) string
+	// whether the database's own placement sorts nulls above every value,
+	// last when ascending
+	NullsSortHigh() bool
+}
+
+type PostgreSQL struct{}
+type MySQL struct{}
+type SQLite struct{}
+type SQLServer struct{}
+
+// DialectNamed returns the Dialect for a DatasetSpec.Dialect value.
+// An empty name selects PostgreSQL.
+func DialectNamed(name string) (Dialect, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "", "postgres", "postgresql", "pg":
+		return PostgreSQL{}, nil
+	case "mysql":
+		return MySQL{}, nil
+	case "sqlite", "sqlite3":
+		return SQLite{}, nil
+	case "sqlserver", "mssql":
+		return SQLServer{}, nil
+	}
+	return nil, fmt.Errorf("Unknown SQL dialect %q", name)
+}
+
+// QuoteQualified quotes each part of a possibly schema qualified name,
+// e.g. sales.orders_v becomes "sales"."orders_v"
+func QuoteQualified(d Dialect, name string) (string, error) {
+	parts := strings.Split(name, ".")
+	quoted := []string{}
+	for _, part := range parts {
+		if len(strings.TrimSpace(part)) == 0 {
+			return "", fmt.Errorf("Invalid identifier %q", name)
+		}
+		quoted = append(quoted, d.QuoteIdent(part))
+	}
+	return strings.Join(quoted, "."), nil
+}
+
+func quoteWith(name string, open string, close string) string {
+	escaped := strings.ReplaceAll(name, close, close+close)
+	return open + escaped + close
+}
+
+func doubleQuoteLiteral(s string) string {
+	return quoteWith(s, "'", "'")
+}
+
+// ilike is Postgres only, the others compare lower() values with like
+func likeOpCodeSQL(op string, shouldNegate bool) (string, string) {
+	sql, valFormat := OpCodeSQL(op, shouldNegate)
+	if strings.HasSuffix(sql, "ilike") {
+		sql = strings.TrimSuffix(sql, "ilike") + "like"
+	}
+	return sql, valFormat
+}
+
+func rollupOf(cols []string) string {
+	return fmt.Sprintf("group by rollup (%s)", strings.Join(cols, ", "))
+}
+
+// nulls first/last is standard, but not supported everywhere
+func nullsOrderTerm(col string, isDesc bool, nulls string) string {
+	term := col
+	if isDesc {
+		term += " desc"
+	}
+	if len(nulls) > 0 {
+		term = fmt.Sprintf("%s nulls %s", term, nulls)
+	}
+	return term
+}
+
+// sorts on whether the column is null first, to place the nulls
+func caseOrderTerm(col string, isDesc bool, nulls string) string {
+	term := col
+	if isDesc {
+		term += " desc"
+	}
+	switch nulls {
+	case NullsFirst:
+		return fmt.Sprintf("case when %s is null then 0 else 1 end, %s", col, term)
+	case NullsLast:
+		return fmt.Sprintf("case when %s is null then 1 else 0 end, %s", col, term)
+	}
+	return term
+}
+
+func limitOffset(maxRecs int, startRec int) (string, string) {
+	return "", fmt.Sprintf("limit %d offset %d", maxRecs, startRec)
+}
+
+// ------------------------------------------------------------
+// PostgreSQL
+// ------------------------------------------------------------
+
+func (PostgreSQL) Name() string {
+	return "postgres"
+}
+
+func (PostgreSQL) QuoteIdent(name string) string {
+	return quoteWith(name, `"`, `"`)
+}
+
+// quotes are doubled, and a literal holding a backslash is written as an
+// escape string, so it reads the same whatever standard_conforming_strings
+func (PostgreSQL) QuoteLiteral(s string) string {
+	if !strings.Contains(s, `\`) {
+		return doubleQuoteLiteral(s)
+	}
+	escaped := strings.ReplaceAll(s, `\`, `\\`)
+	return "E" + doubleQuoteLiteral(escaped)
+}
+
+func (PostgreSQL) Placeholder(pos int) string {
+	return fmt.Sprintf("$%d", pos)
+}
+
+func (PostgreSQL) BindValue(v interface{}) interface{} {
+	return v
+}
+
+func (PostgreSQL) OpCodeSQL(op string, shouldNegate bool) (string, string) {
+	return OpCodeSQL(op, shouldNegate)
+}
+
+func (PostgreSQL) FoldCase(expr string) string {
+	return expr
+}
+
+func (PostgreSQL) Paging(maxRecs int, startRec int, isOrdered bool) (string, string) {
+	return limitOffset(maxRecs, startRec)
+}
+
+func (PostgreSQL) GroupByRollup(cols []string) string {
+	return rollupOf(cols)
+}
+
+func (PostgreSQL) OrderTerm(col string, isDesc bool, nulls string) string {
+	return nullsOrderTerm(col, isDesc, nulls)
+}
+
+func (PostgreSQL) NullsSortHigh() bool {
+	return true
+}
+
+// ------------------------------------------------------------
+// MySQL
+// ------------------------------------------------------------
+
+func (MySQL) Name() string {
+	return "mysql"
+}
+
+func (MySQL) QuoteIdent(name string) string {
+	return quoteWith(name, "`", "`")
+}
+
+// MySQL treats backslash as an escape character by default
+func (MySQL) QuoteLiteral(s string) string {
+	escaped := strings.ReplaceAll(s, `\`, `\\`)
+	escaped = strings.ReplaceAll(escaped, "'", `\'`)
+	return "'" + escaped + "'"
+}
+
+func (MySQL) Placeholder(pos int) string {
+	return "?"
+}
+
+func (MySQL) BindValue(v interface{}) interface{} {
+	return v
+}
+
+func (MySQL) OpCodeSQL(op string, shouldNegate bool) (string, string) {
+	return likeOpCodeSQL(op, shouldNegate)
+}
+
+func (MySQL) FoldCase(expr string) string {
+	return fmt.Sprintf("lower(%s)", expr)
+}
+
+func (MySQL) Paging(maxRecs int, startRec int, isOrdered bool) (string, string) {
+	return limitOffset(maxRecs, startRec)
+}
+
+func (MySQL) GroupByRollup(cols []string) string {
+	return fmt.Sprintf("group by %s with rollup", strings.Join(cols, ", "))
+}
+
+func (MySQL) OrderTerm(col string, isDesc bool, nulls string) string {
+	return caseOrderTerm(col, isDesc, nulls)
+}
+
+func (MySQL) NullsSortHigh() bool {
+	return false
+}
+
+// ------------------------------------------------------------
+// SQLite
+// ------------------------------------------------------------
+
+func (SQLite) Name() string {
+	return "sqlite"
+}
+
+func (SQLite) QuoteIdent(name string) string {
+	return quoteWith(name, `"`, `"`)
+}
+
+func (SQLite) QuoteLiteral(s string) string {
+	return doubleQuoteLiteral(s)
+}
+
+func (SQLite) Placeholder(pos int) string {
+	return "?"
+}
+
+// SQLite has no date type, dates are stored and compared as text
+func (SQLite) BindValue(v interface{}) interface{} {
+	if tm, ok := v.(time.Time); ok {
+		return tm.Format("2006-01-02")
+	}
+	return v
+}
+
+func (SQLite) OpCodeSQL(op string, shouldNegate bool) (string, string) {
+	return likeOpCodeSQL(op, shouldNegate)
+}
+
+func (SQLite) FoldCase(expr string) string {
+	return fmt.Sprintf("lower(%s)", expr)
+}
+
+func (SQLite) Paging(maxRecs int, startRec int, isOrdered bool) (string, string) {
+	return limitOffset(maxRecs, startRec)
+}
+
+// SQLite has no rollup, see FormatRollupQuery
+func (SQLite) GroupByRollup(cols []string) string {
+	return ""
+}
+
+func (SQLite) OrderTerm(col string, isDesc bool, nulls string) string {
+	return nullsOrderTerm(col, isDesc, nulls)
+}
+
+func (SQLite) NullsSortHigh() bool {
+	return false
+}
+
+// ------------------------------------------------------------
+// SQL Server
+// ------------------------------------------------------------
+
+func (SQLServer) Name() string {
+	return "sqlserver"
+}
+
+func (SQLServer) QuoteIdent(name string) string {
+	return quoteWith(name, "[", "]")
+}
+
+func (SQLServer) QuoteLiteral(s string) string {
+	return doubleQuoteLiteral(s)
+}
+
+func (SQLServer) Placeholder(pos int) string {
+	return fmt.Sprintf("@p%d", pos)
+}
+
+func (SQLServer) BindValue(v interface{}) interface{} {
+	return v
+}
+
+func (SQLServer) OpCodeSQL(op string, shouldNegate bool) (string, string) {
+	return likeOpCodeSQL(op, shouldNegate)
+}
+
+func (SQLServer) FoldCase(expr string) string {
+	return fmt.Sprintf("lower(%s)", expr)
+}
+
+// offset/fetch is only legal after an order by
+func (SQLServer) Paging(maxRecs int, startRec int, isOrdered bool) (string, string) {
+	if startRec == 0 {
+		return fmt.Sprintf("top %d", maxRecs), ""
+	}
+	fetch := fmt.Sprintf("offset %d rows fetch next %d rows only", startRec, maxRecs)
+	if !isOrdered {
+		fetch = fmt.Sprintf("order by (select null) %s", fetch)
+	}
+	return "", fetch
+}
+
+func (SQLServer) GroupByRollup(cols []string) string {
+	return rollupOf(cols)
+}
+
+func (SQLServer) OrderTerm(col string, isDesc bool, nulls string) string {
+	return caseOrderTerm(col, isDesc, nulls)
+}
+
+func (SQLServer) NullsSortHigh() bool {
+	return false
+}
@@ -0,0 +1,136 @@
+package repmeta
+
+import (
+	"fmt"
+	"strings"
+)
+
+type tokenKind int
+
+const (
+	tokIdent tokenKind = iota
+	tokNumber
+	tokString
+	tokOp
+	tokLParen
+	tokRParen
+	tokComma
+)
+
+// exprToken is one token of a field or column expression
+type exprToken struct {
+	kind tokenKind
+	text string // string literals keep their quotes
+	pos  int
+}
+
+// the longest operators are listed first
+var exprOps = []string{"<=", ">=", "<>", "!=", "||", "+", "-", "*", "/", "%", "<", ">", "="}
+
+func isIdentStart(ch byte) bool {
+	return ch == '_' || (ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z')
+}
+
+func isDigit(ch byte) bool {
+	return ch >= '0' && ch <= '9'
+}
+
+// tokenizeExpr splits an expression into identifiers, numbers, single
+// quoted strings, operators, parentheses and commas. Comments, other
+// quotes and backslashes are rejected, as the expression may be written
+// into a query.
+func tokenizeExpr(expr string) ([]exprToken, error) {
+	allTokens := []exprToken{}
+	for pos := 0; pos < len(expr); {
+		ch := expr[pos]
+		start := pos
+		switch {
+		case ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r':
+			pos++
+			continue
+		case strings.HasPrefix(expr[pos:], "--") || strings.HasPrefix(expr[pos:], "/*"):
+			return nil, fmt.Errorf("Comments are not allowed, found at position %d", pos)
+		case isIdentStart(ch):
+			for pos < len(expr) && (isIdentStart(expr[pos]) || isDigit(expr[pos])) {
+				pos++
+			}
+			allTokens = append(allTokens, exprToken{kind: tokIdent, text: expr[start:pos], pos: start})
+			continue
+		case isDigit(ch) || (ch == '.' && pos+1 < len(expr) && isDigit(expr[pos+1])):
+			for pos < len(expr) && (isDigit(expr[pos]) || expr[pos] == '.') {
+				pos++
+			}
+			allTokens = append(allTokens, exprToken{kind: tokNumber, text: expr[start:pos], pos: start})
+			continue
+		case ch == '\'':
+			pos++
+			for {
+				end := strings.IndexByte(expr[pos:], '\'')
+				if end == -1 {
+					return nil, fmt.Errorf("Unterminated string at position %d", start)
+				}
+				pos += end + 1
+				// '' is a quote within the string
+				if pos < len(expr) && expr[pos] == '\'' {
+					pos++
+					continue
+				}
+				break
+			}
+			text := expr[start:pos]
+			if strings.Contains(text, `\`) {
+				return nil, fmt.Errorf("Backslashes are not allowed in the string at position %d", start)
+			}
+			allTokens = append(allTokens, exprToken{kind: tokString, text: text, pos: start})
+			continue
+		case ch == '(':
+			allTokens = append(allTokens, exprToken{kind: tokLParen, text: "(", pos: start})
+			pos++
+			continue
+		case ch == ')':
+			allTokens = append(allTokens, exprToken{kind: tokRParen, text: ")", pos: start})
+			pos++
+			continue
+		case ch == ',':
+			allTokens = append(allTokens, exprToken{kind: tokComma, text: ",", pos: start})
+			pos++
+			continue
+		}
+		op := ""
+		for _, candidate := range exprOps {
+			if strings.HasPrefix(expr[pos:], candidate) {
+				op = candidate
+				break
+			}
+		}
+		if len(op) == 0 {
+			return nil, fmt.Errorf("Unexpected %q at position %d", string(ch), pos)
+		}
+		allTokens = append(allTokens, exprToken{kind: tokOp, text: op, pos: start})
+		pos += len(op)
+	}
+	if len(allTokens) == 0 {
+		return nil, fmt.Errorf("Expression is empty")
+	}
+	return allTokens, nil
+}
+
+// checkParens reports unbalanced parentheses
+func checkParens(allTokens []exprToken) error {
+	depth := 0
+	for _, tok := range allTokens {
+		switch tok.kind {
+		case tokLParen:
+			depth++
+		case tokRParen:
+			depth--
+			if depth < 0 {
+				return fmt.Errorf("Unexpected ) at position %d", tok.pos)
+			}
+		}
+	}
+	if depth != 0 {
+		return fmt.Errorf("Unbalanced parentheses")
+	}
+	return nil
+}
@@ -0,0 +1,215 @@
+package repmeta
+
+import (
+	"fmt"
+	"strings"
+)
+
+// words an Expr may use that are not fields
+var sqlExprKeywords = map[string]bool{
+	"and": true, "or": true, "not": true, "null": true, "is": true,
+	"in": true, "between": true, "like": true, "true": true, "false": true,
+	"case": true, "when": true, "then": true, "else": true, "end": true,
+	"as": true, "interval": true,
+}
+
+// an Expr is the value of a single row, so it cannot total rows
+var sqlAggregates = map[string]bool{
+	"sum": true, "avg": true, "min": true, "max": true, "count": true,
+}
+
+// the functions an Expr may call, as calling any other, e.g. pg_sleep
+// or set_config, would let a DatasetSpec run arbitrary SQL
+var sqlFunctions = map[string]bool{
+	"abs": true, "round": true, "floor": true, "coalesce": true, "nullif": true,
+	"lower": true, "upper": true, "trim": true, "ltrim": true, "rtrim": true,
+	"replace": true, "cast": true,
+}
+
+// the types an Expr may cast to, following "as"
+var sqlExprTypes = map[string]bool{
+	"int": true, "integer": true, "bigint": true, "smallint": true,
+	"signed": true, "unsigned": true, "numeric": true, "decimal": true,
+	"real": true, "float": true, "double": true, "text": true, "char": true,
+	"varchar": true, "nvarchar": true, "date": true, "datetime": true,
+	"timestamp": true, "time": true, "boolean": true, "bit": true,
+}
+
+// functions of one dialect, by Dialect.Name
+var dialectFunctions = map[string]map[string]bool{
+	"postgres": {
+		"ceil": true, "ceiling": true, "trunc": true, "sign": true, "mod": true,
+		"length": true, "char_length": true, "substr": true, "substring": true,
+		"left": true, "right": true, "concat": true, "greatest": true, "least": true,
+		"date_trunc": true, "date_part": true, "to_char": true, "age": true,
+	},
+	"mysql": {
+		"ceil": true, "ceiling": true, "truncate": true, "sign": true, "mod": true,
+		"length": true, "char_length": true, "substr": true, "substring": true,
+		"left": true, "right": true, "concat": true, "greatest": true, "least": true,
+		"ifnull": true, "if": true, "year": true, "month": true, "day": true,
+		"date_format": true, "datediff": true,
+	},
+	"sqlite": {
+		"length": true, "substr": true, "instr": true, "ifnull": true, "iif": true,
+		"date": true, "strftime": true, "julianday": true,
+	},
+	"sqlserver": {
+		"ceiling": true, "sign": true, "len": true, "substring": true,
+		"left": true, "right": true, "concat": true, "isnull": true, "iif": true,
+		"year": true, "month": true, "day": true, "datepart": true, "datediff": true,
+	},
+}
+
+// isExprFunction reports whether an Expr rendered for d may call the
+// function named word, which is lower case. Only the functions of every
+// dialect are allowed when d is nil.
+func isExprFunction(d Dialect, word string) bool {
+	if sqlFunctions[word] {
+		return true
+	}
+	return d != nil && dialectFunctions[d.Name()][word]
+}
+
+// exprToken kinds within an Expr, once each identifier is resolved
+const (
+	exprField = iota
+	exprKeyword
+	exprFunction
+	exprType
+	exprOther
+)
+
+// parseFieldExpr tokenizes the Expr of fld, classifying each token.
+// Identifiers must be fields of the dataset, keywords, the functions
+// allowed for the dialect d or a known type following "as".
+func (ds *DatasetSpec) parseFieldExpr(d Dialect, fld *FieldSpec) ([]exprToken, []int, error) {
+	allTokens, err := tokenizeExpr(fld.Expr)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := checkParens(allTokens); err != nil {
+		return nil, nil, err
+	}
+	allKinds := []int{}
+	for idx, tok := range allTokens {
+		if tok.kind != tokIdent {
+			allKinds = append(allKinds, exprOther)
+			continue
+		}
+		word := strings.ToLower(tok.text)
+		isCall := idx+1 < len(allTokens) && allTokens[idx+1].kind == tokLParen
+		switch {
+		case isCall && sqlAggregates[word]:
+			return nil, nil, fmt.Errorf("Aggregate %q is not allowed, use a CalcType", tok.text)
+		case idx > 0 && strings.EqualFold(allTokens[idx-1].text, "as"):
+			if !sqlExprTypes[word] {
+				return nil, nil, fmt.Errorf("Type %q is not allowed", tok.text)
+			}
+			allKinds = append(allKinds, exprType)
+		case sqlExprKeywords[word]:
+			allKinds = append(allKinds, exprKeyword)
+		case isCall && !isExprFunction(d, word):
+			return nil, nil, fmt.Errorf("Function %q is not allowed", tok.text)
+		case isCall:
+			allKinds = append(allKinds, exprFunction)
+		case tok.text == fld.FldName:
+			return nil, nil, fmt.Errorf("Field %q refers to itself", fld.FldName)
+		default:
+			fldIdx, _ := ds.FieldNamed(tok.text)
+			if fldIdx == -1 {
+				return nil, nil, fmt.Errorf("%q is not a field of dataset %q", tok.text, ds.DatasetName)
+			}
+			allKinds = append(allKinds, exprField)
+		}
+	}
+	return allTokens, allKinds, nil
+}
+
+// exprRefs lists the fields the Expr of fld refers to
+func (ds *DatasetSpec) exprRefs(d Dialect, fld *FieldSpec) []string {
+	allTokens, allKinds, err := ds.parseFieldExpr(d, fld)
+	if err != nil {
+		return nil
+	}
+	allRefs := []string{}
+	for idx, tok := range allTokens {
+		if allKinds[idx] == exprField && !containsString(allRefs, tok.text) {
+			allRefs = append(allRefs, tok.text)
+		}
+	}
+	return allRefs
+}
+
+// exprCycle reports fields whose Exprs refer to each other
+type exprCycle struct {
+	FldName string
+	Path    []string
+}
+
+func (ec *exprCycle) Error() string {
+	return fmt.Sprintf("Expression of field %q refers back to itself through %s", ec.FldName, strings.Join(ec.Path, ", "))
+}
+
+// checkExpr reports an invalid Expr for the field named fldName, or for
+// a field it refers to, including a cycle of references, when rendered
+// for the dialect d
+func (ds *DatasetSpec) checkExpr(d Dialect, fldName string) error {
+	return ds.checkExprFrom(d, fldName, []string{})
+}
+
+func (ds *DatasetSpec) checkExprFrom(d Dialect, fldName string, path []string) error {
+	_, pFld := ds.FieldNamed(fldName)
+	if pFld == nil || len(pFld.Expr) == 0 {
+		return nil
+	}
+	if containsString(path, fldName) {
+		return &exprCycle{FldName: fldName, Path: path}
+	}
+	if _, _, err := ds.parseFieldExpr(d, pFld); err != nil {
+		return fmt.Errorf("Expression of field %q: %s", fldName, err.Error())
+	}
+	path = append(path, fldName)
+	for _, ref := range ds.exprRefs(d, pFld) {
+		if err := ds.checkExprFrom(d, ref, path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkExprs reports the first field with an invalid Expr for d
+func (ds *DatasetSpec) checkExprs(d Dialect) error {
+	for _, fld := range ds.Fields {
+		if err := ds.checkExpr(d, fld.FldName); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// exprSQL renders the Expr of fld, each field it refers to replaced by
+// that field's own SQL. Queries fail on an invalid Expr before they get
+// here, "null" only keeps a cycle from recursing forever.
+func (ds *DatasetSpec) exprSQL(d Dialect, fld *FieldSpec) string {
+	if err := ds.checkExpr(d, fld.FldName); err != nil {
+		return "null"
+	}
+	allTokens, allKinds, _ := ds.parseFieldExpr(d, fld)
+	var sb strings.Builder
+	for idx, tok := range allTokens {
+		if idx > 0 {
+			prev := allTokens[idx-1]
+			isCall := allKinds[idx-1] == exprFunction
+			if !isCall && prev.kind != tokLParen && tok.kind != tokRParen && tok.kind != tokComma {
+				sb.WriteString(" ")
+			}
+		}
+		if allKinds[idx] == exprField {
+			sb.WriteString(ds.fieldSQL(d, tok.text))
+		} else {
+			sb.WriteString(tok.text)
+		}
+	}
+	return fmt.Sprintf("(%s)", sb.String())
+}
@@ -0,0 +1,77 @@
+package repmeta
+
+import (
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+// exprDataset is salesDataset with a field "calc" of the Expr expr, and
+// fields "a" and "b" whose Exprs refer to each other
+func exprDataset(expr string) DatasetSpec {
+	ds := salesDataset()
+	ds.Fields = append(ds.Fields,
+		FieldSpec{FldName: "calc", FldType: "float", Expr: expr},
+		FieldSpec{FldName: "a", FldType: "int", Expr: "b + 1"},
+		FieldSpec{FldName: "b", FldType: "int", Expr: "a + 1"},
+	)
+	return ds
+}
+
+func TestCheckExpr(t *testing.T) {
+	for _, tc := range []struct {
+		expr string
+		d    Dialect
+		want string // the start of the error, empty when the Expr is valid
+	}{
+		{"round(amount * 1.1, 2)", PostgreSQL{}, ""},
+		{"coalesce(qty, 0) + abs(price)", SQLServer{}, ""},
+		{"cast(qty as real) / 2", SQLite{}, ""},
+		{"cast(customer as varchar(20))", MySQL{}, ""},
+		{"date_trunc('month', sold)", PostgreSQL{}, ""},
+		{"strftime('%Y', sold)", SQLite{}, ""},
+		{"case when qty is null then 0 else qty end", nil, ""},
+		{"strftime('%Y', sold)", PostgreSQL{}, `Expression of field "calc": Function "strftime" is not allowed`},
+		{"date_trunc('month', sold)", nil, `Expression of field "calc": Function "date_trunc" is not allowed`},
+		{"pg_sleep(10)", PostgreSQL{}, `Expression of field "calc": Function "pg_sleep" is not allowed`},
+		{"set_config('a', 'b', false)", PostgreSQL{}, `Expression of field "calc": Function "set_config" is not allowed`},
+		{"cast(amount as pg_sleep(10))", PostgreSQL{}, `Expression of field "calc": Type "pg_sleep" is not allowed`},
+		{"cast(amount as qty)", PostgreSQL{}, `Expression of field "calc": Type "qty" is not allowed`},
+		{"sum(amount)", SQLite{}, `Expression of field "calc": Aggregate "sum" is not allowed`},
+		{"cast(qty as count(id))", SQLite{}, `Expression of field "calc": Aggregate "count" is not allowed`},
+		{"calc + 1", SQLite{}, `Expression of field "calc": Field "calc" refers to itself`},
+		{"a * 2", SQLite{}, `Expression of field "a" refers back to itself through calc, a, b`},
+		{"nope + 1", SQLite{}, `Expression of field "calc": "nope" is not a field`},
+	} {
+		ds := exprDataset(tc.expr)
+		err := ds.checkExpr(tc.d, "calc")
+		switch {
+		case len(tc.want) == 0 && err != nil:
+			t.Errorf("%q: %s", tc.expr, err)
+		case len(tc.want) > 0 && (err == nil || !strings.HasPrefix(err.Error(), tc.want)):
+			t.Errorf("%q: %v, expected %q", tc.expr, err, tc.want)
+		}
+	}
+}
+
+// a query checks Exprs for the dialect it is rendered in, not the one
+// its dataset names
+func TestExprUsesQueryDialect(t *testing.T) {
+	spec := salesReport()
+	spec.Dataset = exprDataset("strftime('%Y', sold)")
+	spec.Columns = append(spec.Columns, ColumnSpec{FldName: "calc"})
+	logger := zap.NewNop().Sugar()
+
+	if _, _, err := FormatQueryWith(spec, -1, QueryOptions{}, logger); err == nil || !strings.Contains(err.Error(), `"a" refers back`) {
+		t.Errorf("%v, expected the cycle of a and b", err)
+	}
+	spec.Dataset.Fields = spec.Dataset.Fields[:len(spec.Dataset.Fields)-2]
+	if _, _, err := FormatQueryWith(spec, -1, QueryOptions{}, logger); err != nil {
+		t.Errorf("SQLite: %s", err)
+	}
+	_, _, err := FormatQueryWith(spec, -1, QueryOptions{Dialect: PostgreSQL{}}, logger)
+	if err == nil || !strings.Contains(err.Error(), `Function "strftime" is not allowed`) {
+		t.Errorf("PostgreSQL: %v, expected strftime to be rejected", err)
+	}
+}
@@ -33,8 +33,28 @@ type FieldSpec struct {
 	ColType       string
 	Description   string
 	CanFilter     bool
+	IsMultiSelect bool   // the pick list allows several values, for an in filter
+	SelectValue   string // the field holding the pick list values, FldName when empty
+	SelectName    string // the field holding the pick list labels, SelectValue when empty
+	Table         string // the JoinSpec.Alias of the table holding the field, ViewName when empty
+	Column        string // the column holding the field, FldName when empty
+	Expr          string // a SQL expression over other fields, in place of a column
 }
 
 func (fld FieldSpec) String() string {
 	return fmt.Sprintf("%s(%s) %q Grp: %t Hidden: %t Calc: %t  Filter: %t", fld.FldName, fld.FldType, fld.ColName, fld.CanGroup, fld.DefaultHidden, fld.CanCalc, fld.CanFilter)
 }
+
+// PickFields returns the fields supplying the values and labels of the
+// field's pick list
+func (fld FieldSpec) PickFields() (string, string) {
+	valueFld := fld.SelectValue
+	if len(valueFld) == 0 {
+		valueFld = fld.FldName
+	}
+	nameFld := fld.SelectName
+	if len(nameFld) == 0 {
+		nameFld = valueFld
+	}
+	return valueFld, nameFld
+}
@@ -2,7 +2,10 @@ package repmeta
 
 import (
 	"fmt"
+	"math"
+	"strconv"
 	"strings"
+	"time"
 
 	"go.uber.org/zap"
 )
@@ -28,13 +31,77 @@ func (fs *FilterSpec) String() string {
 	return fmt.Sprintf("%s %q %s", fs.FldName, fs.Op, allValues)
 }
 
+// FilterError reports a filter of a ReportSpec that cannot be rendered.
+// Index is the filter's position in the Filters holding it, -1 when a
+// FilterGroup itself is invalid.
+type FilterError struct {
+	Index   int
+	Path    string // e.g. Filters[2] or FilterTree.Groups[0].Filters[1]
+	FldName string
+	Err     error
+}
+
+func (fe *FilterError) Error() string {
+	if len(fe.FldName) == 0 {
+		return fmt.Sprintf("Invalid filter %s: %s", fe.Path, fe.Err.Error())
+	}
+	return fmt.Sprintf("Invalid filter %s on field %q: %s", fe.Path, fe.FldName, fe.Err.Error())
+}
+
+func (fe *FilterError) Unwrap() error {
+	return fe.Err
+}
+
 func SingleQuote(s string) string {
 	escapedStr := strings.ReplaceAll(s, "'", "\\'")
 	parts := []string{"'", "'"}
 	return strings.Join(parts, escapedStr)
 }
 
+// SQLArgs collects the bind arguments of a parameterized query
+type SQLArgs struct {
+	Values  []interface{}
+	dialect Dialect
+}
+
+// NewSQLArgs uses the placeholder style of d, or PostgreSQL when d is nil
+func NewSQLArgs(d Dialect) *SQLArgs {
+	if d == nil {
+		d = PostgreSQL{}
+	}
+	return &SQLArgs{dialect: d}
+}
+
+// Bind records v and returns its positional placeholder
+func (sa *SQLArgs) Bind(v interface{}) string {
+	if sa.dialect == nil {
+		sa.dialect = PostgreSQL{}
+	}
+	sa.Values = append(sa.Values, sa.dialect.BindValue(v))
+	return sa.dialect.Placeholder(len(sa.Values))
+}
+
 func (fs FilterSpec) WhereTerm(ds *DatasetSpec, logger *zap.SugaredLogger) (string, error) {
+	if ds == nil {
+		return "", fmt.Errorf("Dataset not provided for filter named %q", fs.FldName)
+	}
+	d, err := ds.SQLDialect()
+	if err != nil {
+		return "", err
+	}
+	return fs.whereTerm(ds, d, nil, logger)
+}
+
+// WhereTermArgs renders the term with placeholders, appending the
+// typed filter values to args
+func (fs FilterSpec) WhereTermArgs(ds *DatasetSpec, args *SQLArgs, logger *zap.SugaredLogger) (string, error) {
+	if args == nil {
+		return "", fmt.Errorf("No bind arguments provided for filter named %q", fs.FldName)
+	}
+	return fs.whereTerm(ds, args.dialect, args, logger)
+}
+
+func (fs FilterSpec) whereTerm(ds *DatasetSpec, d Dialect, args *SQLArgs, logger *zap.SugaredLogger) (string, error) {
 	if ds == nil {
 		return "", fmt.Errorf("Dataset not provided for filter named %q", fs.FldName)
 	}
@@ -42,22 +109,70 @@ func (fs FilterSpec) WhereTerm(ds *DatasetSpec, logger *zap.SugaredLogger) (stri
 	if idx == -1 {
 		return "", fmt.Errorf("Filter field named %q not found in dataset %q", fs.FldName, ds.DatasetName)
 	}
+	if err := fs.unboundParam(); err != nil {
+		return "", err
+	}
+	if err := ds.checkExpr(d, fs.FldName); err != nil {
+		return "", err
+	}
+	// relative dates not already resolved are resolved against today
+	fs, err := fs.ResolveDates(ds, DateContext{})
+	if err != nil {
+		return "", err
+	}
+
+	return fs.comparisonSQL(d, ds.fieldSQL(d, fs.FldName), pFld.FldType, args)
+}
 
+// comparisonSQL renders the term comparing column, holding values of
+// fldType, with the filter's values. When it fails, nothing it bound is
+// left in args.
+func (fs FilterSpec) comparisonSQL(d Dialect, column string, fldType string, args *SQLArgs) (_ string, err error) {
+	if args != nil {
+		numArgs := len(args.Values)
+		defer func() {
+			if err != nil {
+				args.Values = args.Values[:numArgs]
+			}
+		}()
+	}
 	parts := []string{}
 	shouldNegate := fs.HasOption("not")
 
 	// is there a value expected for this term?
 	expectsValue := fs.Op != "exists"
 
-	parts = append(parts, fs.FldName)
-	opcode, valFormat := OpCodeSQL(fs.Op, shouldNegate)
-	parts = append(parts, opcode)
+	opcode, valFormat := d.OpCodeSQL(fs.Op, shouldNegate)
+	if opcode == "" {
+		return "", fmt.Errorf("Unknown opcode %q for filter named %q", fs.Op, fs.FldName)
+	}
+	isLike := strings.HasSuffix(opcode, "like")
+	if isLike {
+		column = d.FoldCase(column)
+	}
+	parts = append(parts, column, opcode)
 
-	compValue := ComparisonVal(fs.Values, valFormat, pFld.FldType, opcode)
+	compValue := ""
+	if args == nil {
+		compValue, err = comparisonVal(d, fs.Values, valFormat, fldType, opcode)
+	} else {
+		compValue, err = ComparisonArgs(fs.Values, valFormat, fldType, opcode, args)
+	}
+	if err != nil {
+		return "", fmt.Errorf("Filter field named %q: %s", fs.FldName, err.Error())
+	}
 	hasValue := compValue != ""
+	if hasValue && isLike {
+		compValue = d.FoldCase(compValue)
+	}
 	if hasValue && expectsValue {
 		parts = append(parts, compValue)
 	}
+	// \ escapes a wildcard, as in likeMatch, which SQLite and SQL Server
+	// only honour when told
+	if hasValue && isLike {
+		parts = append(parts, "escape", d.QuoteLiteral(`\`))
+	}
 
 	if hasValue != expectsValue {
 		return "", fmt.Errorf("Value expected for opcode %q: %t  Value provided %t", fs.Op, expectsValue, hasValue)
@@ -67,42 +182,157 @@ func (fs FilterSpec) WhereTerm(ds *DatasetSpec, logger *zap.SugaredLogger) (stri
 	return term, nil
 }
 
+// ComparisonVal renders the values as Postgres literals, "" when one of
+// them is not valid for typ
 func ComparisonVal(values []string, valFormat string, typ string, opcode string) string {
-	if strings.HasSuffix(opcode, " null") {
+	compValue, err := comparisonVal(PostgreSQL{}, values, valFormat, typ, opcode)
+	if err != nil {
 		return ""
 	}
+	return compValue
+}
+
+// comparisonVal writes every value as a literal of typ, converted by
+// TypedValue first so that nothing is spliced into the SQL as given
+func comparisonVal(d Dialect, values []string, valFormat string, typ string, opcode string) (string, error) {
+	if strings.HasSuffix(opcode, " null") {
+		return "", nil
+	}
 	if len(values) < 1 {
-		return ""
+		return "", nil
+	}
+	if strings.HasSuffix(opcode, "like") {
+		return d.QuoteLiteral(fmt.Sprintf(valFormat, values[0])), nil
 	}
-	needsQuotes := typ == "text" || typ == "date"
-	if strings.HasSuffix(opcode, "ilike") {
-		return SingleQuote(fmt.Sprintf(valFormat, values[0]))
+	isBetween := strings.HasSuffix(opcode, "between")
+	if isBetween && len(values) != 2 {
+		return "", nil
 	}
-	if strings.HasSuffix(opcode, "in") {
-		allVals := []string{}
-		for _, value := range values {
-			val := FormatValue(value, needsQuotes)
-			allVals = append(allVals, val)
+	isIn := strings.HasSuffix(opcode, "in")
+	if !isIn && !isBetween {
+		values = values[:1]
+	}
+	allVals := []string{}
+	for _, value := range values {
+		val, err := literalValue(d, value, typ)
+		if err != nil {
+			return "", err
 		}
-		return fmt.Sprintf("(%s)", strings.Join(allVals, ", "))
+		allVals = append(allVals, val)
+	}
+	switch {
+	case isIn:
+		return fmt.Sprintf("(%s)", strings.Join(allVals, ", ")), nil
+	case isBetween:
+		return fmt.Sprintf(valFormat, allVals[0], allVals[1]), nil
+	}
+	return allVals[0], nil
+}
+
+// literalValue formats the TypedValue of v as a literal of d
+func literalValue(d Dialect, v string, typ string) (string, error) {
+	typedVal, err := TypedValue(v, typ)
+	if err != nil {
+		return "", err
 	}
-	if strings.HasSuffix(opcode, "between") {
-		if len(values) != 2 {
-			return ""
+	switch val := typedVal.(type) {
+	case int64:
+		return strconv.FormatInt(val, 10), nil
+	case float64:
+		return strconv.FormatFloat(val, 'g', -1, 64), nil
+	case bool:
+		return strconv.FormatBool(val), nil
+	case time.Time:
+		return d.QuoteLiteral(val.Format("2006-01-02")), nil
+	case string:
+		return d.QuoteLiteral(val), nil
+	}
+	return "", fmt.Errorf("Value %q cannot be written as a literal", v)
+}
+
+// ComparisonArgs mirrors ComparisonVal, binding typed values instead of
+// splicing them into the SQL text. Every value is typed before any is
+// bound, so a bad value leaves args as it was.
+func ComparisonArgs(values []string, valFormat string, typ string, opcode string, args *SQLArgs) (string, error) {
+	if strings.HasSuffix(opcode, " null") {
+		return "", nil
+	}
+	if len(values) < 1 {
+		return "", nil
+	}
+	if strings.HasSuffix(opcode, "like") {
+		return args.Bind(fmt.Sprintf(valFormat, values[0])), nil
+	}
+	isBetween := strings.HasSuffix(opcode, "between")
+	if isBetween && len(values) != 2 {
+		return "", nil
+	}
+	isIn := strings.HasSuffix(opcode, "in")
+	if !isIn && !isBetween {
+		values = values[:1]
+	}
+	typedVals := []interface{}{}
+	for _, value := range values {
+		typedVal, err := TypedValue(value, typ)
+		if err != nil {
+			return "", err
 		}
-		allVals := []interface{}{}
-		for idx := 0; idx < 2; idx++ {
-			val := FormatValue(values[idx], needsQuotes)
-			allVals = append(allVals, val)
+		typedVals = append(typedVals, typedVal)
+	}
+
+	allPlaceholders := []string{}
+	for _, typedVal := range typedVals {
+		allPlaceholders = append(allPlaceholders, args.Bind(typedVal))
+	}
+	switch {
+	case isIn:
+		return fmt.Sprintf("(%s)", strings.Join(allPlaceholders, ", ")), nil
+	case isBetween:
+		return fmt.Sprintf(valFormat, allPlaceholders[0], allPlaceholders[1]), nil
+	}
+	return allPlaceholders[0], nil
+}
+
+// TypedValue converts a filter value to the Go type matching the field's
+// FldType. Currency values are given in pennies, see DVCurrency.
+func TypedValue(v string, typ string) (interface{}, error) {
+	trimmed := strings.TrimSpace(v)
+	switch typ {
+	case "int", "currency":
+		val, err := strconv.ParseInt(trimmed, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Value %q is not a valid %s", v, typ)
+		}
+		return val, nil
+	case "float":
+		val, err := strconv.ParseFloat(trimmed, 64)
+		if err != nil || math.IsNaN(val) || math.IsInf(val, 0) {
+			return nil, fmt.Errorf("Value %q is not a valid %s", v, typ)
 		}
-		return fmt.Sprintf(valFormat, allVals...)
+		return val, nil
+	case "boolean":
+		val, err := strconv.ParseBool(trimmed)
+		if err != nil {
+			return nil, fmt.Errorf("Value %q is not a valid %s", v, typ)
+		}
+		return val, nil
+	case "date":
+		val, err := time.Parse("2006-01-02", trimmed)
+		if err != nil {
+			return nil, fmt.Errorf("Value %q is not a valid %s", v, typ)
+		}
+		return val, nil
 	}
-	return FormatValue(values[0], needsQuotes)
+	return v, nil
 }
 
 func FormatValue(v string, needsQuotes bool) string {
+	return formatValue(PostgreSQL{}, v, needsQuotes)
+}
+
+func formatValue(d Dialect, v string, needsQuotes bool) string {
 	if needsQuotes {
-		return SingleQuote(v)
+		return d.QuoteLiteral(v)
 	}
 	return v
 }
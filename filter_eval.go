@@ -0,0 +1,237 @@
+package repmeta
+
+import (
+	"fmt"
+	"strings"
+)
+
+// RowPredicate reports whether a DataRow passes a filter
+type RowPredicate func(dR *DataRow) bool
+
+// sqlBool is the result of a SQL condition, which is unknown rather than
+// true or false when it compares a null
+type sqlBool int
+
+const (
+	sqlFalse sqlBool = iota
+	sqlTrue
+	sqlUnknown
+)
+
+func toSQLBool(b bool) sqlBool {
+	if b {
+		return sqlTrue
+	}
+	return sqlFalse
+}
+
+// rowTest evaluates a condition against a DataRow
+type rowTest func(dR *DataRow) sqlBool
+
+// as in a where clause, only rows the condition is true for pass
+func (test rowTest) predicate() RowPredicate {
+	return func(dR *DataRow) bool {
+		return test(dR) == sqlTrue
+	}
+}
+
+// Predicate compiles fs into the Go equivalent of its WhereTerm, for
+// DataRows holding every field of ds in order (see NewDatasetRow).
+// As in SQL, a comparison with a null value is unknown, negated or not,
+// and the row fails.
+func (fs FilterSpec) Predicate(ds *DatasetSpec) (RowPredicate, error) {
+	test, err := fs.test(ds)
+	if err != nil {
+		return nil, err
+	}
+	return test.predicate(), nil
+}
+
+func (fs FilterSpec) test(ds *DatasetSpec) (rowTest, error) {
+	if ds == nil {
+		return nil, fmt.Errorf("Dataset not provided for filter named %q", fs.FldName)
+	}
+	idx, pFld := ds.FieldNamed(fs.FldName)
+	if idx == -1 {
+		return nil, fmt.Errorf("Filter field named %q not found in dataset %q", fs.FldName, ds.DatasetName)
+	}
+	if err := fs.unboundParam(); err != nil {
+		return nil, err
+	}
+	fs, err := fs.ResolveDates(ds, DateContext{})
+	if err != nil {
+		return nil, err
+	}
+	return fs.compile(idx, pFld)
+}
+
+// PredicateFor compiles fs for the DataRows of spec, for filtering rows
+// after they have been queried
+func (fs FilterSpec) PredicateFor(spec *ReportSpec) (RowPredicate, error) {
+	colIdx, pFld := spec.ColumnNamed(fs.FldName)
+	if colIdx == -1 {
+		return nil, fmt.Errorf("Filter field named %q is not a column of the report", fs.FldName)
+	}
+	if err := fs.unboundParam(); err != nil {
+		return nil, err
+	}
+	fs, err := fs.ResolveDates(&spec.Dataset, DateContext{})
+	if err != nil {
+		return nil, err
+	}
+	test, err := fs.compile(colIdx, pFld)
+	if err != nil {
+		return nil, err
+	}
+	return test.predicate(), nil
+}
+
+// CompileFilters ands together the predicates of all filters, nil when
+// there are none
+func CompileFilters(ds *DatasetSpec, filters []FilterSpec) (RowPredicate, error) {
+	var allPreds []RowPredicate
+	for _, filter := range filters {
+		pred, err := filter.Predicate(ds)
+		if err != nil {
+			return nil, err
+		}
+		allPreds = append(allPreds, pred)
+	}
+	return allOf(allPreds), nil
+}
+
+func allOf(allPreds []RowPredicate) RowPredicate {
+	if len(allPreds) == 0 {
+		return nil
+	}
+	return func(dR *DataRow) bool {
+		for _, pred := range allPreds {
+			if !pred(dR) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+func (fs FilterSpec) compile(idx int, pFld *FieldSpec) (rowTest, error) {
+	shouldNegate := fs.HasOption("not")
+	opcode, valFormat := OpCodeSQL(fs.Op, shouldNegate)
+	if opcode == "" {
+		return nil, fmt.Errorf("Unknown opcode %q for filter named %q", fs.Op, fs.FldName)
+	}
+
+	// exists is rendered as "is null"
+	if fs.Op == "exists" {
+		return func(dR *DataRow) sqlBool {
+			return toSQLBool((*dR)[idx].IsNull() != shouldNegate)
+		}, nil
+	}
+
+	if len(fs.Values) < 1 || (fs.Op == "range" && len(fs.Values) != 2) {
+		return nil, fmt.Errorf("Value expected for opcode %q: %t  Value provided %t", fs.Op, true, false)
+	}
+
+	if strings.HasSuffix(opcode, "like") {
+		pattern := fmt.Sprintf(valFormat, fs.Values[0])
+		return func(dR *DataRow) sqlBool {
+			dv := (*dR)[idx]
+			if dv.IsNull() {
+				return sqlUnknown
+			}
+			return toSQLBool(likeMatch(dv.String(), pattern) != shouldNegate)
+		}, nil
+	}
+
+	// pFld has the type of the value tested, which for a total may not be
+	// its field's, e.g. the avg of an int is a float
+	var cmpVals []*DataVal
+	for _, value := range fs.Values {
+		typedVal, err := TypedValue(value, pFld.FldType)
+		if err != nil {
+			return nil, fmt.Errorf("Filter field named %q: %s", fs.FldName, err.Error())
+		}
+		cmpVal := NewDataValTyped(ToDataValType(pFld.FldType))
+		if err := cmpVal.SetValue(typedVal); err != nil {
+			return nil, fmt.Errorf("Filter field named %q: %s", fs.FldName, err.Error())
+		}
+		cmpVals = append(cmpVals, cmpVal)
+	}
+
+	var test func(dv *DataVal) bool
+	switch fs.Op {
+	case "lt":
+		test = func(dv *DataVal) bool { return dv.Compare(cmpVals[0]) < 0 }
+	case "le":
+		test = func(dv *DataVal) bool { return dv.Compare(cmpVals[0]) <= 0 }
+	case "gt":
+		test = func(dv *DataVal) bool { return dv.Compare(cmpVals[0]) > 0 }
+	case "ge":
+		test = func(dv *DataVal) bool { return dv.Compare(cmpVals[0]) >= 0 }
+	case "eq":
+		test = func(dv *DataVal) bool { return dv.Compare(cmpVals[0]) == 0 }
+	case "ne":
+		test = func(dv *DataVal) bool { return dv.Compare(cmpVals[0]) != 0 }
+	case "range":
+		test = func(dv *DataVal) bool {
+			return dv.Compare(cmpVals[0]) >= 0 && dv.Compare(cmpVals[1]) <= 0
+		}
+	case "in":
+		test = func(dv *DataVal) bool {
+			for _, cmpVal := range cmpVals {
+				if dv.Compare(cmpVal) == 0 {
+					return true
+				}
+			}
+			return false
+		}
+	default:
+		return nil, fmt.Errorf("Unknown opcode %q for filter named %q", fs.Op, fs.FldName)
+	}
+
+	return func(dR *DataRow) sqlBool {
+		dv := (*dR)[idx]
+		if dv.IsNull() {
+			return sqlUnknown
+		}
+		return toSQLBool(test(dv) != shouldNegate)
+	}, nil
+}
+
+// likeMatch is a case insensitive sql like, where % matches any run of
+// characters, _ any single character, and \ escapes the next character
+func likeMatch(s string, pattern string) bool {
+	str := []rune(strings.ToLower(s))
+	pat := []rune(strings.ToLower(pattern))
+
+	// matches[i] is true when the pattern so far matches str[:i]
+	matches := make([]bool, len(str)+1)
+	matches[0] = true
+	for pIdx := 0; pIdx < len(pat); pIdx++ {
+		pc := pat[pIdx]
+		isLiteral := false
+		if pc == '\\' && pIdx+1 < len(pat) {
+			pIdx++
+			pc = pat[pIdx]
+			isLiteral = true
+		}
+		next := make([]bool, len(str)+1)
+		switch {
+		case pc == '%' && !isLiteral:
+			seen := false
+			for sIdx := 0; sIdx <= len(str); sIdx++ {
+				seen = seen || matches[sIdx]
+				next[sIdx] = seen
+			}
+		default:
+			for sIdx := 1; sIdx <= len(str); sIdx++ {
+				if !matches[sIdx-1] {
+					continue
+				}
+				next[sIdx] = (pc == '_' && !isLiteral) || str[sIdx-1] == pc
+			}
+		}
+		matches = next
+	}
+	return matches[len(str)]
+}
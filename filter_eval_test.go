@@ -0,0 +1,230 @@
+package repmeta
+
+import (
+	"database/sql"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+	"go.uber.org/zap"
+)
+
+func salesDataset() DatasetSpec {
+	return DatasetSpec{DatasetName: "sales", ViewName: "sales", Dialect: "sqlite", Fields: []FieldSpec{
+		{FldName: "id", FldType: "int"},
+		{FldName: "region", FldType: "text"},
+		{FldName: "customer", FldType: "text"},
+		{FldName: "amount", FldType: "currency"},
+		{FldName: "qty", FldType: "int"},
+		{FldName: "price", FldType: "float"},
+		{FldName: "sold", FldType: "date"},
+	}}
+}
+
+// salesRows are the rows of the sales table, in the order of the fields
+// of salesDataset
+var salesRows = [][]interface{}{
+	{1, "East", "Acme", 1000, 1, 1.5, "2022-01-03"},
+	{2, "East", "acme corp", 2000, nil, 2.5, "2022-02-03"},
+	{3, "East", "Bolt", 500, 3, nil, "2022-03-01"},
+	{4, "West", "ACME", 700, 4, 4.0, "2022-01-09"},
+	{5, "West", nil, nil, 5, 5.0, nil},
+	{6, "West", "Zed_2", 12000, 6, 6.0, "2022-06-01"},
+	{7, nil, "100% Zed", -50, 0, -1.0, "2021-12-31"},
+}
+
+func openSalesDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	stmt := `create table sales (id integer, region text, customer text, amount integer, qty integer, price real, sold text)`
+	if _, err := db.Exec(stmt); err != nil {
+		t.Fatal(err)
+	}
+	for _, row := range salesRows {
+		if _, err := db.Exec(`insert into sales values (?, ?, ?, ?, ?, ?, ?)`, row...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return db
+}
+
+// sqlIDs runs the WhereTerm of fs against the sales table
+func sqlIDs(t *testing.T, db *sql.DB, ds *DatasetSpec, fs FilterSpec) []int64 {
+	t.Helper()
+	args := NewSQLArgs(SQLite{})
+	term, err := fs.WhereTermArgs(ds, args, zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatalf("%s: %s", fs.String(), err)
+	}
+	rows, err := db.Query(fmt.Sprintf("select id from sales where %s order by id", term), args.Values...)
+	if err != nil {
+		t.Fatalf("%s: %s", term, err)
+	}
+	defer rows.Close()
+	ids := []int64{}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return ids
+}
+
+// predicateIDs runs the Predicate of fs over salesRows
+func predicateIDs(t *testing.T, ds *DatasetSpec, fs FilterSpec) []int64 {
+	t.Helper()
+	pred, err := fs.Predicate(ds)
+	if err != nil {
+		t.Fatalf("%s: %s", fs.String(), err)
+	}
+	ids := []int64{}
+	for _, row := range salesRows {
+		dR := NewDatasetRow(ds)
+		for idx, val := range row {
+			if err := (*dR)[idx].SetValue(val); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if pred(dR) {
+			id, _ := (*dR)[0].Int64()
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+func TestPredicateMatchesWhereTerm(t *testing.T) {
+	db := openSalesDB(t)
+	ds := salesDataset()
+
+	allFilters := []FilterSpec{
+		{FldName: "amount", Op: "lt", Values: []string{"1000"}},
+		{FldName: "amount", Op: "le", Values: []string{"1000"}},
+		{FldName: "qty", Op: "gt", Values: []string{"3"}},
+		{FldName: "price", Op: "ge", Values: []string{"2.5"}},
+		{FldName: "region", Op: "eq", Values: []string{"East"}},
+		{FldName: "region", Op: "ne", Values: []string{"East"}},
+		{FldName: "qty", Op: "range", Values: []string{"1", "4"}},
+		{FldName: "sold", Op: "range", Values: []string{"2022-01-01", "2022-02-28"}},
+		{FldName: "sold", Op: "lt", Values: []string{"2022-02-01"}},
+		{FldName: "region", Op: "in", Values: []string{"West", "North"}},
+		{FldName: "amount", Op: "in", Values: []string{"500", "700", "-50"}},
+		{FldName: "customer", Op: "prefix", Values: []string{"acme"}},
+		{FldName: "customer", Op: "suffix", Values: []string{"ZED"}},
+		{FldName: "customer", Op: "contains", Values: []string{"Me"}},
+		{FldName: "customer", Op: "contains", Values: []string{"d_"}},
+		{FldName: "customer", Op: "contains", Values: []string{`d\_`}},
+		{FldName: "customer", Op: "prefix", Values: []string{`100\%`}},
+		{FldName: "customer", Op: "prefix", Values: []string{"_c"}},
+		{FldName: "sold", Op: "exists"},
+		{FldName: "amount", Op: "exists"},
+	}
+	for _, fs := range allFilters {
+		for _, opts := range [][]string{nil, {"not"}} {
+			fs.Options = opts
+			t.Run(fmt.Sprintf("%s %s %s %v", fs.FldName, fs.Op, strings.Join(fs.Values, ","), opts), func(t *testing.T) {
+				want := sqlIDs(t, db, &ds, fs)
+				got := predicateIDs(t, &ds, fs)
+				if !reflect.DeepEqual(got, want) {
+					t.Errorf("Predicate passed %v, the SQL %v", got, want)
+				}
+			})
+		}
+	}
+}
+
+// a Predicate only reads its compiled values, so rows may be tested
+// from several goroutines at once
+func TestPredicateIsShared(t *testing.T) {
+	ds := salesDataset()
+	fs := FilterSpec{FldName: "amount", Op: "in", Values: []string{"500", "700", "-50"}}
+	pred, err := fs.Predicate(&ds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := predicateIDs(t, &ds, fs)
+
+	var wg sync.WaitGroup
+	for worker := 0; worker < 4; worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			count := 0
+			for _, row := range salesRows {
+				dR := NewDatasetRow(&ds)
+				for idx, val := range row {
+					(*dR)[idx].SetValue(val)
+				}
+				if pred(dR) {
+					count++
+				}
+			}
+			if count != len(want) {
+				t.Errorf("Passed %d rows, expected %d", count, len(want))
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// likeMatch folds case, so every dialect must compare case insensitively,
+// with ilike or by folding both sides
+func TestLikeIsCaseInsensitive(t *testing.T) {
+	allDialects := []Dialect{PostgreSQL{}, MySQL{}, SQLite{}, SQLServer{}}
+	for _, d := range allDialects {
+		for _, op := range []string{"prefix", "suffix", "contains"} {
+			for _, shouldNegate := range []bool{false, true} {
+				fs := FilterSpec{FldName: "customer", Op: op, Values: []string{"Acme"}}
+				if shouldNegate {
+					fs.Options = []string{"not"}
+				}
+				term, err := fs.comparisonSQL(d, d.QuoteIdent("customer"), "text", nil)
+				if err != nil {
+					t.Fatalf("%s %s: %s", d.Name(), op, err)
+				}
+
+				opcode, valFormat := d.OpCodeSQL(op, shouldNegate)
+				column := d.QuoteIdent("customer")
+				value := d.QuoteLiteral(fmt.Sprintf(valFormat, "Acme"))
+				if !strings.HasSuffix(opcode, "ilike") {
+					column = d.FoldCase(column)
+					value = d.FoldCase(value)
+					if column == d.QuoteIdent("customer") {
+						t.Errorf("%s: like without folding case", d.Name())
+					}
+				}
+				want := fmt.Sprintf("%s %s %s escape ", column, opcode, value)
+				if !strings.HasPrefix(term, want) {
+					t.Errorf("%s: %q does not start %q", d.Name(), term, want)
+				}
+			}
+		}
+	}
+
+	for _, tc := range []struct {
+		s       string
+		pattern string
+	}{
+		{"ACME Corp", "acme%"},
+		{"acme corp", "%CORP"},
+		{"Acme", "_CM_"},
+	} {
+		if !likeMatch(tc.s, tc.pattern) {
+			t.Errorf("%q does not match %q", tc.s, tc.pattern)
+		}
+	}
+}
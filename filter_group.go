@@ -0,0 +1,251 @@
+package repmeta
+
+import (
+	"fmt"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// FilterGroup.Op values
+const (
+	GroupAnd = "and"
+	GroupOr  = "or"
+	GroupNot = "not"
+)
+
+// FilterGroup combines its Filters and nested Groups. "and" and "or" join
+// every member, "not" negates the and of its members. An empty Op is "and".
+//
+//	{"Op": "or",
+//	 "Filters": [{"FldName": "region", "Op": "eq", "Values": ["West"]}],
+//	 "Groups": [{"Op": "and", "Filters": [
+//	   {"FldName": "region", "Op": "eq", "Values": ["East"]},
+//	   {"FldName": "amount", "Op": "gt", "Values": ["1000"]}]}]}
+type FilterGroup struct {
+	Op      string
+	Filters []FilterSpec
+	Groups  []FilterGroup
+}
+
+func (fg *FilterGroup) String() string {
+	allMembers := []string{}
+	for idx := range fg.Filters {
+		allMembers = append(allMembers, fmt.Sprintf("(%s)", fg.Filters[idx].String()))
+	}
+	for idx := range fg.Groups {
+		allMembers = append(allMembers, fmt.Sprintf("(%s)", fg.Groups[idx].String()))
+	}
+	op, _ := fg.groupOp()
+	if op == GroupNot {
+		return fmt.Sprintf("not (%s)", strings.Join(allMembers, " and "))
+	}
+	return strings.Join(allMembers, fmt.Sprintf(" %s ", op))
+}
+
+func (fg *FilterGroup) groupOp() (string, error) {
+	op := strings.ToLower(strings.TrimSpace(fg.Op))
+	switch op {
+	case "":
+		return GroupAnd, nil
+	case GroupAnd, GroupOr, GroupNot:
+		return op, nil
+	}
+	return "", fmt.Errorf("Unknown filter group op %q", fg.Op)
+}
+
+// FldNames lists the field of every filter in the group and its subgroups
+func (fg *FilterGroup) FldNames() []string {
+	fldNames := []string{}
+	for _, filter := range fg.Filters {
+		fldNames = append(fldNames, filter.FldName)
+	}
+	for idx := range fg.Groups {
+		fldNames = append(fldNames, fg.Groups[idx].FldNames()...)
+	}
+	return fldNames
+}
+
+// WhereTerm renders the group as a SQL condition, "" when it has no
+// members. A member that cannot be rendered is reported as a *FilterError.
+func (fg *FilterGroup) WhereTerm(ds *DatasetSpec, logger *zap.SugaredLogger) (string, error) {
+	d, err := ds.SQLDialect()
+	if err != nil {
+		return "", err
+	}
+	return fg.whereTerm(ds, d, nil, false, "", logger)
+}
+
+// path locates the group within the ReportSpec, for error messages
+func (fg *FilterGroup) whereTerm(ds *DatasetSpec, d Dialect, args *SQLArgs, lenient bool, path string, logger *zap.SugaredLogger) (string, error) {
+	op, err := fg.groupOp()
+	if err != nil {
+		return "", &FilterError{Index: -1, Path: path, Err: err}
+	}
+	allTerms, err := fg.memberTerms(ds, d, args, lenient, path, logger)
+	if err != nil || len(allTerms) == 0 {
+		return "", err
+	}
+	if op == GroupNot {
+		return fmt.Sprintf("not (%s)", joinTerms(allTerms, GroupAnd)), nil
+	}
+	return joinTerms(allTerms, op), nil
+}
+
+// memberTerms renders each filter and subgroup. When lenient, members that
+// fail are logged and skipped rather than failing the group.
+func (fg *FilterGroup) memberTerms(ds *DatasetSpec, d Dialect, args *SQLArgs, lenient bool, path string, logger *zap.SugaredLogger) ([]string, error) {
+	allTerms := []string{}
+	for idx, filter := range fg.Filters {
+		term, err := filter.whereTerm(ds, d, args, logger)
+		if err != nil {
+			fltErr := &FilterError{Index: idx, Path: memberPath(path, "Filters", idx), FldName: filter.FldName, Err: err}
+			if !lenient {
+				return nil, fltErr
+			}
+			logger.Warnf("%s", fltErr.Error())
+			continue
+		}
+		allTerms = append(allTerms, term)
+	}
+	for idx := range fg.Groups {
+		term, err := fg.Groups[idx].whereTerm(ds, d, args, lenient, memberPath(path, "Groups", idx), logger)
+		if err != nil {
+			if !lenient {
+				return nil, err
+			}
+			logger.Warnf("%s", err.Error())
+			continue
+		}
+		if len(term) > 0 {
+			allTerms = append(allTerms, term)
+		}
+	}
+	return allTerms, nil
+}
+
+func memberPath(path string, list string, idx int) string {
+	member := fmt.Sprintf("%s[%d]", list, idx)
+	if len(path) == 0 {
+		return member
+	}
+	return path + "." + member
+}
+
+// a lone term is left bare, otherwise every term is parenthesized
+func joinTerms(allTerms []string, op string) string {
+	if len(allTerms) == 1 {
+		return allTerms[0]
+	}
+	wrappedTerms := []string{}
+	for _, term := range allTerms {
+		wrappedTerms = append(wrappedTerms, fmt.Sprintf("(%s)", term))
+	}
+	return strings.Join(wrappedTerms, fmt.Sprintf(" %s ", op))
+}
+
+// Predicate compiles the group for DataRows holding every field of ds.
+// Nulls follow SQL's three valued logic, so not (amount > 10) is no more
+// true of a null amount than amount > 10 is. It is nil for an empty group.
+func (fg *FilterGroup) Predicate(ds *DatasetSpec) (RowPredicate, error) {
+	test, err := fg.test(ds)
+	if err != nil || test == nil {
+		return nil, err
+	}
+	return test.predicate(), nil
+}
+
+func (fg *FilterGroup) test(ds *DatasetSpec) (rowTest, error) {
+	op, err := fg.groupOp()
+	if err != nil {
+		return nil, err
+	}
+	var allTests []rowTest
+	for _, filter := range fg.Filters {
+		test, err := filter.test(ds)
+		if err != nil {
+			return nil, err
+		}
+		allTests = append(allTests, test)
+	}
+	for idx := range fg.Groups {
+		test, err := fg.Groups[idx].test(ds)
+		if err != nil {
+			return nil, err
+		}
+		if test != nil {
+			allTests = append(allTests, test)
+		}
+	}
+	if len(allTests) == 0 {
+		return nil, nil
+	}
+
+	switch op {
+	case GroupOr:
+		return func(dR *DataRow) sqlBool {
+			result := sqlFalse
+			for _, test := range allTests {
+				switch test(dR) {
+				case sqlTrue:
+					return sqlTrue
+				case sqlUnknown:
+					result = sqlUnknown
+				}
+			}
+			return result
+		}, nil
+	case GroupNot:
+		return func(dR *DataRow) sqlBool {
+			switch allTrue(allTests, dR) {
+			case sqlTrue:
+				return sqlFalse
+			case sqlFalse:
+				return sqlTrue
+			}
+			return sqlUnknown
+		}, nil
+	}
+	return func(dR *DataRow) sqlBool {
+		return allTrue(allTests, dR)
+	}, nil
+}
+
+func allTrue(allTests []rowTest, dR *DataRow) sqlBool {
+	result := sqlTrue
+	for _, test := range allTests {
+		switch test(dR) {
+		case sqlFalse:
+			return sqlFalse
+		case sqlUnknown:
+			result = sqlUnknown
+		}
+	}
+	return result
+}
+
+// rootFilterGroup ands the flat Filters of spec with its FilterTree
+func (spec *ReportSpec) rootFilterGroup() *FilterGroup {
+	root := FilterGroup{Op: GroupAnd, Filters: spec.Filters}
+	if spec.FilterTree != nil {
+		root.Groups = []FilterGroup{*spec.FilterTree}
+	}
+	return &root
+}
+
+// FilterPredicate compiles every filter of spec, flat and nested, for
+// DataRows holding every field of the dataset. It is nil when spec has
+// no filters.
+func (spec *ReportSpec) FilterPredicate() (RowPredicate, error) {
+	return spec.FilterPredicateAt(DateContext{})
+}
+
+// FilterPredicateAt is FilterPredicate with relative dates resolved by dc
+func (spec *ReportSpec) FilterPredicateAt(dc DateContext) (RowPredicate, error) {
+	root := spec.rootFilterGroup()
+	if err := root.checkBound(); err != nil {
+		return nil, err
+	}
+	root = root.ResolveDates(&spec.Dataset, dc)
+	return root.Predicate(&spec.Dataset)
+}
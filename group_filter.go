@@ -0,0 +1,154 @@
+package repmeta
+
+import (
+	"fmt"
+	"strings"
+)
+
+// GroupFilterSpec keeps only the groups of one level whose totals pass
+// all of its Filters, e.g. the customers whose total amount is over
+// 1000000 (currency is in pennies). Each filter tests the total of a
+// column, by the column's CalcType, rather than its values.
+//
+// Groups are hidden, not removed from the report: the totals of the
+// enclosing groups and the grand totals still include the rows of the
+// groups left out, whether totalled by ReportWriter or by RunRollup's
+// query, so they need not add up to the groups shown. Use Filters to
+// leave rows out of every total.
+type GroupFilterSpec struct {
+	Group   string       // one of the spec's Groups
+	Filters []FilterSpec // anded
+}
+
+func (gf GroupFilterSpec) String() string {
+	allFilters := []string{}
+	for idx := range gf.Filters {
+		allFilters = append(allFilters, fmt.Sprintf("(%s)", gf.Filters[idx].String()))
+	}
+	return fmt.Sprintf("%s: %s", gf.Group, strings.Join(allFilters, " and "))
+}
+
+// IsTotalOp reports whether op can test a total
+func IsTotalOp(op string) bool {
+	switch op {
+	case "lt", "le", "gt", "ge", "eq", "ne", "range", "in", "exists":
+		return true
+	}
+	return false
+}
+
+// groupLevel is the ReportLevel index of the group named grpName, 0
+// when it is not one of the Groups
+func (spec *ReportSpec) groupLevel(grpName string) int {
+	for idx, group := range spec.Groups {
+		if group == grpName {
+			return idx + 1
+		}
+	}
+	return 0
+}
+
+// totalField describes the total of the column named colName: its index
+// in a DataRow and a FieldSpec with the total's FldType
+func (spec *ReportSpec) totalField(colName string) (int, *FieldSpec, error) {
+	allCalcs := spec.ColumnCalcs()
+	for idx, cs := range spec.Columns {
+		if cs.FldName != colName {
+			continue
+		}
+		colIdx := len(spec.ExtraColumns) + idx
+		if cs.IsComputed() {
+			return colIdx, &FieldSpec{FldName: colName, FldType: fldTypeName(cs.computedType())}, nil
+		}
+		_, pFld := spec.Dataset.FieldNamed(colName)
+		if pFld == nil {
+			break
+		}
+		typ := CalcResultType(allCalcs[colIdx], ToDataValType(pFld.FldType))
+		if typ == DVNone {
+			return -1, nil, fmt.Errorf("Column %q is not totalled", colName)
+		}
+		return colIdx, &FieldSpec{FldName: colName, FldType: fldTypeName(typ)}, nil
+	}
+	return -1, nil, fmt.Errorf("%q is not a column of the report", colName)
+}
+
+// totalTest compiles fs against the totals of a ReportLevel
+func (fs FilterSpec) totalTest(spec *ReportSpec) (rowTest, error) {
+	if !IsTotalOp(fs.Op) {
+		return nil, fmt.Errorf("Op %q cannot test the total of %q", fs.Op, fs.FldName)
+	}
+	colIdx, pTotal, err := spec.totalField(fs.FldName)
+	if err != nil {
+		return nil, err
+	}
+	if err := fs.unboundParam(); err != nil {
+		return nil, err
+	}
+	return fs.compile(colIdx, pTotal)
+}
+
+// compileGroupFilters returns a predicate over the totals of each
+// ReportLevel, nil for the levels without GroupFilters
+func compileGroupFilters(spec *ReportSpec) ([]RowPredicate, error) {
+	levelPreds := make([][]RowPredicate, len(spec.Groups)+1)
+	for _, gf := range spec.GroupFilters {
+		level := spec.groupLevel(gf.Group)
+		if level == 0 {
+			return nil, fmt.Errorf("Group filter on %q, which is not one of the Groups", gf.Group)
+		}
+		for _, fs := range gf.Filters {
+			test, err := fs.totalTest(spec)
+			if err != nil {
+				return nil, fmt.Errorf("Group filter on %q: %s", gf.Group, err.Error())
+			}
+			levelPreds[level] = append(levelPreds[level], test.predicate())
+		}
+	}
+	allPreds := []RowPredicate{}
+	for _, preds := range levelPreds {
+		allPreds = append(allPreds, allOf(preds))
+	}
+	return allPreds, nil
+}
+
+// havingTerms renders the GroupFilters of the level-th group for a
+// having clause. Filters of computed columns are left to ReportWriter,
+// as the database cannot total them.
+func (qp *queryParts) havingTerms(spec *ReportSpec, level int) ([]string, error) {
+	allTerms := []string{}
+	allCalcs := spec.ColumnCalcs()
+	for _, gf := range spec.GroupFilters {
+		if spec.groupLevel(gf.Group) != level {
+			continue
+		}
+		for _, fs := range gf.Filters {
+			if _, err := fs.totalTest(spec); err != nil {
+				return nil, fmt.Errorf("Group filter on %q: %s", gf.Group, err.Error())
+			}
+			colIdx, pTotal, _ := spec.totalField(fs.FldName)
+			if spec.computedAt(colIdx) != nil {
+				continue
+			}
+			_, pFld := spec.Dataset.FieldNamed(fs.FldName)
+			total := totalSQL(qp.d, &spec.Dataset, allCalcs[colIdx], pFld)
+			term, err := fs.comparisonSQL(qp.d, total, pTotal.FldType, qp.args)
+			if err != nil {
+				return nil, fmt.Errorf("Group filter on %q: %s", gf.Group, err.Error())
+			}
+			allTerms = append(allTerms, term)
+		}
+	}
+	return allTerms, nil
+}
+
+// groupFilterRefs lists the parameters the GroupFilters refer to
+func (spec *ReportSpec) groupFilterRefs() []string {
+	names := []string{}
+	for _, gf := range spec.GroupFilters {
+		for idx := range gf.Filters {
+			names = append(names, gf.Filters[idx].paramRefs()...)
+		}
+	}
+	return names
+}
@@ -0,0 +1,61 @@
+package repmeta
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+// customerReport keeps the customers whose total amount is over 15.00
+func customerReport() *ReportSpec {
+	spec := salesReport()
+	spec.Groups = []string{"region", "customer"}
+	spec.ExtraColumns = []string{"region", "customer"}
+	spec.GroupFilters = []GroupFilterSpec{{Group: "customer", Filters: []FilterSpec{{FldName: "amount", Op: "gt", Values: []string{"1500"}}}}}
+	return spec
+}
+
+func TestGroupFilterHidesGroups(t *testing.T) {
+	db := openSalesDB(t)
+	spec := customerReport()
+
+	var b bytes.Buffer
+	rW := NewReportWriter(zap.NewNop().Sugar(), &b, OTJSON, "sales", false, spec, nil, "")
+	if _, err := RunWith(context.Background(), db, spec, rW, QueryOptions{Dialect: SQLite{}}); err != nil {
+		t.Fatal(err)
+	}
+	detailIDs := []string{}
+	scanner := bufio.NewScanner(bytes.NewReader(b.Bytes()))
+	for scanner.Scan() {
+		var row ReportRow
+		if err := json.Unmarshal(scanner.Bytes(), &row); err != nil {
+			t.Fatal(err)
+		}
+		if row.RowType == "DET" {
+			detailIDs = append(detailIDs, row.Values[2])
+		}
+	}
+	if want := []string{"2", "6"}; !reflect.DeepEqual(detailIDs, want) {
+		t.Errorf("Detail rows of ids %v, expected %v", detailIDs, want)
+	}
+
+	// the regions and the grand total still include the hidden customers
+	want := []ReportRow{
+		{RowType: "SUM", RowLevel: 2, LevelName: "acme corp", LevelCount: 1, Values: []string{"", "", "1", "20.00"}},
+		{RowType: "SUM", RowLevel: 1, LevelName: "East", LevelCount: 3, Values: []string{"", "", "3", "35.00"}},
+		{RowType: "SUM", RowLevel: 2, LevelName: "Zed_2", LevelCount: 1, Values: []string{"", "", "1", "120.00"}},
+		{RowType: "SUM", RowLevel: 1, LevelName: "West", LevelCount: 3, Values: []string{"", "", "3", "127.00"}},
+		{RowType: "TOT", RowLevel: 0, LevelName: "Grand Totals", LevelCount: 6, Values: []string{"", "", "6", "162.00"}},
+	}
+	if got := summaryRows(t, &b); !reflect.DeepEqual(got, want) {
+		t.Errorf("Totals %v, expected %v", got, want)
+	}
+	if got := runTotals(t, spec, true); !reflect.DeepEqual(got, want) {
+		t.Errorf("Rollup totals %v, expected %v", got, want)
+	}
+}
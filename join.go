@@ -0,0 +1,214 @@
+package repmeta
+
+import (
+	"fmt"
+	"strings"
+)
+
+// JoinSpec.Type values
+const (
+	JoinInner = "inner"
+	JoinLeft  = "left"
+)
+
+// JoinSpec joins a table to the dataset's ViewName. The fields of the
+// joined table name its Alias as their Table. A query leaves out a left
+// join that none of its fields are read from, so a left join must match
+// at most one row, on a unique key, or the report's rows would depend on
+// the fields chosen. Inner joins filter rows, and are always made.
+type JoinSpec struct {
+	Table string // possibly schema qualified
+	Alias string
+	Type  string    // inner when empty
+	On    []JoinKey // anded
+}
+
+// JoinKey equates a column of the joined table with a column of the
+// ViewName or of an earlier join
+type JoinKey struct {
+	From string // alias.column, or a column of the ViewName
+	To   string // a column of the joined table
+}
+
+func (js JoinSpec) joinType() string {
+	if len(js.Type) == 0 {
+		return JoinInner
+	}
+	return strings.ToLower(js.Type)
+}
+
+// fromParts splits From into its alias, "" for the ViewName, and column
+func (jk JoinKey) fromParts() (string, string) {
+	parts := strings.SplitN(jk.From, ".", 2)
+	if len(parts) == 1 {
+		return "", parts[0]
+	}
+	return parts[0], parts[1]
+}
+
+// baseAlias qualifies the columns of the ViewName when there are Joins
+func (ds *DatasetSpec) baseAlias() string {
+	if len(ds.Alias) > 0 {
+		return ds.Alias
+	}
+	parts := strings.Split(ds.ViewName, ".")
+	return parts[len(parts)-1]
+}
+
+// checkJoins reports the first join that cannot be rendered
+func (ds *DatasetSpec) checkJoins() error {
+	aliases := []string{ds.baseAlias()}
+	for _, join := range ds.Joins {
+		if len(strings.TrimSpace(join.Alias)) == 0 {
+			return fmt.Errorf("Join of %q has no alias", join.Table)
+		}
+		if containsString(aliases, join.Alias) {
+			return fmt.Errorf("Join alias %q is used more than once", join.Alias)
+		}
+		switch join.joinType() {
+		case JoinInner, JoinLeft:
+		default:
+			return fmt.Errorf("Unknown join type %q for join %q", join.Type, join.Alias)
+		}
+		if len(join.On) == 0 {
+			return fmt.Errorf("Join %q has no keys", join.Alias)
+		}
+		for _, key := range join.On {
+			alias, column := key.fromParts()
+			if len(column) == 0 || len(key.To) == 0 {
+				return fmt.Errorf("Join %q has an incomplete key", join.Alias)
+			}
+			if len(alias) > 0 && !containsString(aliases, alias) {
+				return fmt.Errorf("Join %q refers to %q before it is joined", join.Alias, alias)
+			}
+		}
+		aliases = append(aliases, join.Alias)
+	}
+	for _, fld := range ds.Fields {
+		if len(fld.Table) > 0 && !containsString(aliases, fld.Table) {
+			return fmt.Errorf("Field %q refers to unknown table %q", fld.FldName, fld.Table)
+		}
+	}
+	return nil
+}
+
+// fieldSQL is the column holding the field named fldName, qualified by
+// its table when the dataset has Joins, or the field's Expr
+func (ds *DatasetSpec) fieldSQL(d Dialect, fldName string) string {
+	column := fldName
+	table := ""
+	if _, pFld := ds.FieldNamed(fldName); pFld != nil {
+		if len(pFld.Expr) > 0 {
+			return ds.exprSQL(d, pFld)
+		}
+		if len(pFld.Column) > 0 {
+			column = pFld.Column
+		}
+		table = pFld.Table
+	}
+	if len(ds.Joins) == 0 {
+		return d.QuoteIdent(column)
+	}
+	if len(table) == 0 {
+		table = ds.baseAlias()
+	}
+	return fmt.Sprintf("%s.%s", d.QuoteIdent(table), d.QuoteIdent(column))
+}
+
+// selectSQL names a selected column after its field when they differ
+func (ds *DatasetSpec) selectSQL(d Dialect, fldName string) string {
+	col := ds.fieldSQL(d, fldName)
+	if col == d.QuoteIdent(fldName) {
+		return col
+	}
+	return fmt.Sprintf("%s as %s", col, d.QuoteIdent(fldName))
+}
+
+func fieldSQLs(d Dialect, ds *DatasetSpec, fldNames []string) []string {
+	allCols := []string{}
+	for _, fldName := range fldNames {
+		allCols = append(allCols, ds.fieldSQL(d, fldName))
+	}
+	return allCols
+}
+
+func selectSQLs(d Dialect, ds *DatasetSpec, fldNames []string) []string {
+	allCols := []string{}
+	for _, fldName := range fldNames {
+		allCols = append(allCols, ds.selectSQL(d, fldName))
+	}
+	return allCols
+}
+
+// fieldTables marks the tables the field named fldName is read from,
+// through its Expr when it has one
+func (ds *DatasetSpec) fieldTables(d Dialect, fldName string, needed map[string]bool) {
+	_, pFld := ds.FieldNamed(fldName)
+	switch {
+	case pFld == nil:
+	case len(pFld.Expr) > 0:
+		if ds.checkExpr(d, fldName) != nil {
+			return
+		}
+		for _, ref := range ds.exprRefs(d, pFld) {
+			ds.fieldTables(d, ref, needed)
+		}
+	case len(pFld.Table) > 0:
+		needed[pFld.Table] = true
+	}
+}
+
+// fromSQL renders the ViewName followed by its inner joins and the left
+// joins that the fields fldNames are read from, along with the joins
+// those refer to
+func (ds *DatasetSpec) fromSQL(d Dialect, fldNames []string) (string, error) {
+	table, err := QuoteQualified(d, ds.ViewName)
+	if err != nil || len(ds.Joins) == 0 {
+		return table, err
+	}
+	if err := ds.checkJoins(); err != nil {
+		return "", err
+	}
+
+	needed := map[string]bool{}
+	for _, fldName := range fldNames {
+		ds.fieldTables(d, fldName, needed)
+	}
+	for _, join := range ds.Joins {
+		if join.joinType() == JoinInner {
+			needed[join.Alias] = true
+		}
+	}
+	// a join only refers to earlier ones
+	for idx := len(ds.Joins) - 1; idx >= 0; idx-- {
+		if !needed[ds.Joins[idx].Alias] {
+			continue
+		}
+		for _, key := range ds.Joins[idx].On {
+			if alias, _ := key.fromParts(); len(alias) > 0 {
+				needed[alias] = true
+			}
+		}
+	}
+
+	parts := []string{fmt.Sprintf("%s %s", table, d.QuoteIdent(ds.baseAlias()))}
+	for _, join := range ds.Joins {
+		if !needed[join.Alias] {
+			continue
+		}
+		joinTable, err := QuoteQualified(d, join.Table)
+		if err != nil {
+			return "", err
+		}
+		allKeys := []string{}
+		for _, key := range join.On {
+			alias, column := key.fromParts()
+			if len(alias) == 0 {
+				alias = ds.baseAlias()
+			}
+			allKeys = append(allKeys, fmt.Sprintf("%s.%s = %s.%s", d.QuoteIdent(join.Alias), d.QuoteIdent(key.To), d.QuoteIdent(alias), d.QuoteIdent(column)))
+		}
+		parts = append(parts, fmt.Sprintf("%s join %s %s on %s", join.joinType(), joinTable, d.QuoteIdent(join.Alias), strings.Join(allKeys, " and ")))
+	}
+	return strings.Join(parts, " "), nil
+}
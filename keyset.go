@@ -0,0 +1,209 @@
+package repmeta
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// pageKey is the content of a page token: the value of each order field
+// in the last row of a page
+type pageKey struct {
+	Fields []string `json:"f"`
+	Values []string `json:"v"`
+	Nulls  []bool   `json:"n,omitempty"` // which of the Values are null
+}
+
+func (key *pageKey) isNull(idx int) bool {
+	return idx < len(key.Nulls) && key.Nulls[idx]
+}
+
+// KeysetFields lists the fields keyset paging orders rows by, the Groups
+// and Sorts followed by the KeyField
+func (spec *ReportSpec) KeysetFields() ([]string, error) {
+	allSorts, err := spec.keysetOrder()
+	if err != nil {
+		return nil, err
+	}
+	return sortFldNames(allSorts), nil
+}
+
+func (spec *ReportSpec) keysetOrder() ([]SortSpec, error) {
+	if len(spec.KeyField) == 0 {
+		return nil, fmt.Errorf("Keyset paging requires a KeyField")
+	}
+	allSorts := spec.OrderSpecs()
+	if !containsString(sortFldNames(allSorts), spec.KeyField) {
+		allSorts = append(allSorts, SortSpec{FldName: spec.KeyField})
+	}
+	// nulls are placed explicitly, as seekTerm must know where they are.
+	// By default they sort low, as in DataVal.Compare.
+	for idx := range allSorts {
+		if len(allSorts[idx].Nulls) > 0 {
+			continue
+		}
+		allSorts[idx].Nulls = NullsFirst
+		if allSorts[idx].IsDesc() {
+			allSorts[idx].Nulls = NullsLast
+		}
+	}
+	return allSorts, nil
+}
+
+// NextPageToken returns the QueryOptions.After token for the page that
+// follows lastRow, the final DataRow of a keyset paged query. The order
+// fields must be columns of the report.
+func NextPageToken(spec *ReportSpec, lastRow *DataRow) (string, error) {
+	fldNames, err := spec.KeysetFields()
+	if err != nil {
+		return "", err
+	}
+	key := pageKey{Fields: fldNames}
+	for _, fldName := range fldNames {
+		colIdx, _ := spec.ColumnNamed(fldName)
+		if colIdx == -1 || colIdx >= len(*lastRow) {
+			return "", fmt.Errorf("Keyset field %q is not a column of the report", fldName)
+		}
+		dv := (*lastRow)[colIdx]
+		key.Values = append(key.Values, tokenValue(dv))
+		key.Nulls = append(key.Nulls, dv.IsNull())
+	}
+	data, err := json.Marshal(key)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// tokenValue formats dv as a filter value, so currency is in pennies
+func tokenValue(dv *DataVal) string {
+	switch dv.Typ {
+	case DVInt, DVCurrency:
+		val, _ := dv.Int64()
+		return strconv.FormatInt(val, 10)
+	case DVFloat:
+		val, _ := dv.Float64()
+		return strconv.FormatFloat(val, 'g', -1, 64)
+	case DVBoolean:
+		val, _ := dv.Bool()
+		return strconv.FormatBool(val)
+	}
+	return dv.String()
+}
+
+func decodePageToken(fldNames []string, token string) (*pageKey, error) {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid page token")
+	}
+	var key pageKey
+	if err := json.Unmarshal(data, &key); err != nil {
+		return nil, fmt.Errorf("Invalid page token")
+	}
+	if strings.Join(key.Fields, ",") != strings.Join(fldNames, ",") || len(key.Values) != len(fldNames) ||
+		(len(key.Nulls) > 0 && len(key.Nulls) != len(fldNames)) {
+		return nil, fmt.Errorf("Page token does not match the order of the report")
+	}
+	return &key, nil
+}
+
+// seekTerm selects the rows ordered after qp.after, expanded into
+// (g1 > x) or (g1 = x and g2 > y) or ... Descending fields seek with <.
+// A row value comparison (g1, g2) > (x, y) is not used, as it is unknown
+// rather than true when a field is null. Nulls are placed by keysetOrder,
+// so a null is ordered after x when nulls are last, and a null in the
+// token is followed by every value when nulls are first.
+func (qp *queryParts) seekTerm(spec *ReportSpec) (string, error) {
+	d := qp.d
+	key := qp.after
+	cols := fieldSQLs(d, &spec.Dataset, key.Fields)
+	allTypes := []string{}
+	for _, fldName := range key.Fields {
+		_, pFld := spec.Dataset.FieldNamed(fldName)
+		if pFld == nil {
+			return "", fmt.Errorf("Keyset field %q is not a field of dataset %q", fldName, spec.Dataset.DatasetName)
+		}
+		allTypes = append(allTypes, pFld.FldType)
+	}
+	// values are bound in the order they appear in the text
+	valueSQL := func(idx int) (string, error) {
+		typedVal, err := TypedValue(key.Values[idx], allTypes[idx])
+		if err != nil {
+			return "", fmt.Errorf("Page token: %s", err.Error())
+		}
+		if qp.args != nil {
+			return qp.args.Bind(typedVal), nil
+		}
+		return literalValue(d, key.Values[idx], allTypes[idx])
+	}
+	equalSQL := func(idx int) (string, error) {
+		if key.isNull(idx) {
+			return fmt.Sprintf("%s is null", cols[idx]), nil
+		}
+		val, err := valueSQL(idx)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%s = %s", cols[idx], val), nil
+	}
+	// afterSQL is "" when nothing is ordered after the token's value
+	afterSQL := func(idx int) (string, error) {
+		ss := qp.order[idx]
+		nullsLast := ss.nulls() == NullsLast
+		if key.isNull(idx) {
+			if nullsLast {
+				return "", nil
+			}
+			return fmt.Sprintf("%s is not null", cols[idx]), nil
+		}
+		val, err := valueSQL(idx)
+		if err != nil {
+			return "", err
+		}
+		op := ">"
+		if ss.IsDesc() {
+			op = "<"
+		}
+		term := fmt.Sprintf("%s %s %s", cols[idx], op, val)
+		if nullsLast {
+			term = fmt.Sprintf("(%s or %s is null)", term, cols[idx])
+		}
+		return term, nil
+	}
+
+	allTerms := []string{}
+	for last := range cols {
+		// the values bound by a term that is left out are taken back
+		numArgs := 0
+		if qp.args != nil {
+			numArgs = len(qp.args.Values)
+		}
+		parts := []string{}
+		for idx := 0; idx < last; idx++ {
+			term, err := equalSQL(idx)
+			if err != nil {
+				return "", err
+			}
+			parts = append(parts, term)
+		}
+		term, err := afterSQL(last)
+		if err != nil {
+			return "", err
+		}
+		if len(term) == 0 {
+			if qp.args != nil {
+				qp.args.Values = qp.args.Values[:numArgs]
+			}
+			continue
+		}
+		parts = append(parts, term)
+		allTerms = append(allTerms, strings.Join(parts, " and "))
+	}
+	if len(allTerms) == 0 {
+		// the token is the last row there can be
+		return "1 = 0", nil
+	}
+	return joinTerms(allTerms, GroupOr), nil
+}
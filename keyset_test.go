@@ -0,0 +1,134 @@
+package repmeta
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"reflect"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func keysetReport(allSorts []SortSpec) *ReportSpec {
+	return &ReportSpec{
+		Dataset:      salesDataset(),
+		Columns:      []ColumnSpec{{FldName: "id"}, {FldName: "amount"}, {FldName: "sold"}, {FldName: "customer"}, {FldName: "qty"}},
+		Groups:       []string{"region"},
+		ExtraColumns: []string{"region"},
+		Sorts:        allSorts,
+		KeyField:     "id",
+	}
+}
+
+// queryIDs runs a query of spec, returning the id of each row and the
+// last row read
+func queryIDs(t *testing.T, db *sql.DB, spec *ReportSpec, maxRecs int, opts QueryOptions) ([]int64, *DataRow) {
+	t.Helper()
+	qry, args, err := FormatQueryWith(spec, maxRecs, opts, zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows, err := db.Query(qry, args...)
+	if err != nil {
+		t.Fatalf("%s: %s", qry, err)
+	}
+	defer rows.Close()
+
+	dR, err := NewDataRow(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idIdx, _ := spec.ColumnNamed("id")
+	ids := []int64{}
+	for rows.Next() {
+		if err := rows.Scan(spec.ScanPointers(dR)...); err != nil {
+			t.Fatal(err)
+		}
+		id, _ := (*dR)[idIdx].Int64()
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return ids, dR
+}
+
+func TestKeysetPagesHoldEveryRow(t *testing.T) {
+	db := openSalesDB(t)
+
+	allOrders := [][]SortSpec{
+		nil,
+		{{FldName: "amount"}},
+		{{FldName: "amount", Direction: SortDesc}},
+		{{FldName: "amount", Nulls: NullsLast}},
+		{{FldName: "sold", Direction: SortDesc, Nulls: NullsFirst}, {FldName: "customer"}},
+		{{FldName: "customer", Direction: SortDesc}, {FldName: "qty", Nulls: NullsLast}},
+	}
+	for _, allSorts := range allOrders {
+		spec := keysetReport(allSorts)
+		for _, bindArgs := range []bool{true, false} {
+			opts := QueryOptions{Dialect: SQLite{}, BindArgs: bindArgs, Keyset: true}
+			want, _ := queryIDs(t, db, spec, -1, opts)
+			if len(want) != len(salesRows) {
+				t.Fatalf("%v: read %d rows, expected %d", allSorts, len(want), len(salesRows))
+			}
+
+			for _, pageSize := range []int{1, 2, 3} {
+				got := []int64{}
+				for page := 0; page <= len(salesRows); page++ {
+					ids, lastRow := queryIDs(t, db, spec, pageSize, opts)
+					got = append(got, ids...)
+					if len(ids) < pageSize {
+						break
+					}
+					token, err := NextPageToken(spec, lastRow)
+					if err != nil {
+						t.Fatal(err)
+					}
+					opts.After = token
+				}
+				opts.After = ""
+				if !reflect.DeepEqual(got, want) {
+					t.Errorf("%v, bind %t, pages of %d: %v, expected %v", allSorts, bindArgs, pageSize, got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestKeysetRejectsForeignTokens(t *testing.T) {
+	db := openSalesDB(t)
+	logger := zap.NewNop().Sugar()
+
+	spec := keysetReport([]SortSpec{{FldName: "amount"}})
+	opts := QueryOptions{Dialect: SQLite{}, BindArgs: true, Keyset: true}
+	_, lastRow := queryIDs(t, db, spec, 2, opts)
+	token, err := NextPageToken(spec, lastRow)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	badAmount := base64.RawURLEncoding.EncodeToString([]byte(`{"f":["region","amount","id"],"v":["East","1 or 1=1","1"]}`))
+	for _, tc := range []struct {
+		spec  *ReportSpec
+		token string
+		want  string
+	}{
+		{spec, token[:len(token)-3] + "xyz", "Invalid page token"},
+		{spec, "!" + token, "Invalid page token"},
+		{spec, badAmount, "Page token: Value \"1 or 1=1\" is not a valid currency"},
+		{keysetReport([]SortSpec{{FldName: "qty"}}), token, "Page token does not match the order of the report"},
+		{keysetReport(nil), token, "Page token does not match the order of the report"},
+	} {
+		opts.After = tc.token
+		_, _, err := FormatQueryWith(tc.spec, 2, opts, logger)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: %v, expected %q", tc.token, err, tc.want)
+		}
+	}
+
+	if _, _, err := FormatQueryWith(spec, 2, QueryOptions{Dialect: SQLite{}, After: token}, logger); err == nil {
+		t.Error("A page token was accepted without keyset paging")
+	}
+}
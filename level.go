@@ -7,6 +7,7 @@ import (
 
 type ReportLevel struct {
 	Totals    *DataRow
+	Aggs      []*Aggregate
 	FldName   string
 	FldSpec   *FieldSpec
 	FldIdx    int
@@ -31,11 +32,17 @@ func NewReportLevel(spec *ReportSpec, groupName string) (*ReportLevel, error) {
 	// 	hasField = false
 	// }
 	rL := new(ReportLevel)
-	totals, err := NewDataRow(spec)
+	aggs, err := NewAggregates(spec)
 	if err != nil {
 		return nil, err
 	}
-	rL.Totals = totals
+	// Totals shares its values with the aggregates
+	var totals DataRow
+	for _, agg := range aggs {
+		totals = append(totals, agg.Result)
+	}
+	rL.Aggs = aggs
+	rL.Totals = &totals
 	rL.FldName = groupName
 	rL.FldIdx = fldIdx
 	// if hasField {
@@ -52,14 +59,10 @@ func (lvl *ReportLevel) AsText() string {
 }
 
 func (lvl *ReportLevel) DidAccumulate(row *DataRow) bool {
-	isNumeric := Numerics()
 	didSucceed := true
-	for idx, pTotFld := range *lvl.Totals {
-		fldType := pTotFld.Typ
-		if _, ok := isNumeric[fldType]; ok {
-			if !pTotFld.DidAccumulate((*row)[idx]) {
-				didSucceed = false
-			}
+	for idx, agg := range lvl.Aggs {
+		if !agg.Accumulate((*row)[idx]) {
+			didSucceed = false
 		}
 	}
 	if didSucceed {
@@ -68,6 +71,14 @@ func (lvl *ReportLevel) DidAccumulate(row *DataRow) bool {
 	return didSucceed
 }
 
+// Merge folds the totals of other, a level of the same spec, into lvl
+func (lvl *ReportLevel) Merge(other *ReportLevel) {
+	for idx, agg := range lvl.Aggs {
+		agg.Merge(other.Aggs[idx])
+	}
+	lvl.TotCount += other.TotCount
+}
+
 func (lvl *ReportLevel) TabString() string {
 	return fmt.Sprintf("%s", lvl.Totals.TabString())
 }
@@ -89,6 +100,8 @@ func (lvl *ReportLevel) String() string {
 }
 
 func (lvl *ReportLevel) ResetNumerics() {
-	lvl.Totals.ResetNumerics()
+	for _, agg := range lvl.Aggs {
+		agg.Reset()
+	}
 	return
 }
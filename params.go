@@ -0,0 +1,259 @@
+package repmeta
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ParamSpec declares a value supplied when a report is run. Filters refer
+// to it with a value of "{{ParamName}}", which is replaced by every value
+// bound to the parameter, so an "in" filter can take several.
+//
+//	{"ParamName": "region", "Prompt": "Which region?", "ParamType": "text",
+//	 "Required": true, "Allowed": ["East", "West"]}
+type ParamSpec struct {
+	ParamName string
+	Prompt    string
+	ParamType string   // a FldType, text when empty
+	Default   []string // used when no value is supplied
+	Required  bool
+	Allowed   []string // any value of ParamType when empty
+}
+
+func (ps *ParamSpec) String() string {
+	return fmt.Sprintf("%s %s %v", ps.ParamName, ps.paramType(), ps.Default)
+}
+
+func (ps *ParamSpec) paramType() string {
+	if len(ps.ParamType) == 0 {
+		return "text"
+	}
+	return ps.ParamType
+}
+
+// ParamRef returns the parameter name of a "{{name}}" filter value
+func ParamRef(v string) (string, bool) {
+	trimmed := strings.TrimSpace(v)
+	if !strings.HasPrefix(trimmed, "{{") || !strings.HasSuffix(trimmed, "}}") {
+		return "", false
+	}
+	name := strings.TrimSpace(trimmed[2 : len(trimmed)-2])
+	return name, len(name) > 0
+}
+
+// paramRefs lists the parameters a filter refers to
+func (fs *FilterSpec) paramRefs() []string {
+	names := []string{}
+	for _, value := range fs.Values {
+		if name, isRef := ParamRef(value); isRef {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// unboundParam is an error for a filter still referring to a parameter
+func (fs *FilterSpec) unboundParam() error {
+	names := fs.paramRefs()
+	if len(names) == 0 {
+		return nil
+	}
+	return fmt.Errorf("Filter field named %q refers to unbound parameter %q", fs.FldName, names[0])
+}
+
+func (fg *FilterGroup) paramRefs() []string {
+	names := []string{}
+	for idx := range fg.Filters {
+		names = append(names, fg.Filters[idx].paramRefs()...)
+	}
+	for idx := range fg.Groups {
+		names = append(names, fg.Groups[idx].paramRefs()...)
+	}
+	return names
+}
+
+// a report cannot be run until every parameter is bound
+func (fg *FilterGroup) checkBound() error {
+	names := fg.paramRefs()
+	if len(names) > 0 {
+		return fmt.Errorf("Parameter %q is not bound, see BindParams", names[0])
+	}
+	return nil
+}
+
+// ParamNamed returns the declared parameter, nil when there is none
+func (spec *ReportSpec) ParamNamed(name string) *ParamSpec {
+	for idx := range spec.Parameters {
+		if spec.Parameters[idx].ParamName == name {
+			return &spec.Parameters[idx]
+		}
+	}
+	return nil
+}
+
+// RequiredParams lists, in declaration order, the parameters the filters
+// of spec refer to, for prompting before the report is run. It is an
+// error for a filter to refer to an undeclared parameter.
+func (spec *ReportSpec) RequiredParams() ([]ParamSpec, error) {
+	referenced := map[string]bool{}
+	allRefs := append(spec.rootFilterGroup().paramRefs(), spec.groupFilterRefs()...)
+	for _, name := range allRefs {
+		if spec.ParamNamed(name) == nil {
+			return nil, fmt.Errorf("Parameter %q is not declared", name)
+		}
+		referenced[name] = true
+	}
+	allParams := []ParamSpec{}
+	for _, param := range spec.Parameters {
+		if referenced[param.ParamName] {
+			allParams = append(allParams, param)
+		}
+	}
+	return allParams, nil
+}
+
+// BindParams returns a copy of spec with every parameter reference in its
+// filters replaced by the values supplied for it, or else its Default.
+// Values are checked against the parameter's type and Allowed values.
+// A filter referring to an optional parameter left without a value is
+// taken as true, so the report is not narrowed by it: it is dropped, and
+// so is any "or" group holding it. It is an error for every member of a
+// "not" group to be dropped, as the group would then exclude every row.
+func BindParams(spec *ReportSpec, values map[string][]string) (*ReportSpec, error) {
+	names := []string{}
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if spec.ParamNamed(name) == nil {
+			return nil, fmt.Errorf("Unknown parameter %q", name)
+		}
+	}
+	if _, err := spec.RequiredParams(); err != nil {
+		return nil, err
+	}
+
+	bound := map[string][]string{}
+	for _, param := range spec.Parameters {
+		paramVals := values[param.ParamName]
+		if len(paramVals) == 0 {
+			paramVals = param.Default
+		}
+		if len(paramVals) == 0 {
+			if param.Required {
+				return nil, fmt.Errorf("Parameter %q is required", param.ParamName)
+			}
+			continue
+		}
+		if err := param.check(paramVals); err != nil {
+			return nil, err
+		}
+		bound[param.ParamName] = paramVals
+	}
+
+	boundSpec := *spec
+	boundSpec.Filters, _ = bindFilters(spec.Filters, bound)
+	if spec.FilterTree != nil {
+		boundTree, isTrue, err := bindGroup(spec.FilterTree, bound, "FilterTree")
+		if err != nil {
+			return nil, err
+		}
+		boundSpec.FilterTree = boundTree
+		if isTrue {
+			boundSpec.FilterTree = nil
+		}
+	}
+	boundSpec.GroupFilters = nil
+	for _, gf := range spec.GroupFilters {
+		boundFilters, _ := bindFilters(gf.Filters, bound)
+		boundFilter := GroupFilterSpec{Group: gf.Group, Filters: boundFilters}
+		boundSpec.GroupFilters = append(boundSpec.GroupFilters, boundFilter)
+	}
+	return &boundSpec, nil
+}
+
+func (ps *ParamSpec) check(paramVals []string) error {
+	for _, value := range paramVals {
+		if _, err := TypedValue(value, ps.paramType()); err != nil {
+			return fmt.Errorf("Parameter %q: %s", ps.ParamName, err.Error())
+		}
+		if len(ps.Allowed) > 0 && !containsString(ps.Allowed, value) {
+			return fmt.Errorf("Parameter %q: value %q is not allowed", ps.ParamName, value)
+		}
+	}
+	return nil
+}
+
+func containsString(allStrings []string, s string) bool {
+	for _, str := range allStrings {
+		if str == s {
+			return true
+		}
+	}
+	return false
+}
+
+// filters referring to a parameter without values are left out, and
+// reported as dropped
+func bindFilters(filters []FilterSpec, bound map[string][]string) ([]FilterSpec, bool) {
+	boundFilters := []FilterSpec{}
+	isDropped := false
+	for _, filter := range filters {
+		boundVals := []string{}
+		isBound := true
+		for _, value := range filter.Values {
+			name, isRef := ParamRef(value)
+			if !isRef {
+				boundVals = append(boundVals, value)
+				continue
+			}
+			paramVals, ok := bound[name]
+			if !ok {
+				isBound = false
+				break
+			}
+			boundVals = append(boundVals, paramVals...)
+		}
+		if !isBound {
+			isDropped = true
+			continue
+		}
+		filter.Values = boundVals
+		boundFilters = append(boundFilters, filter)
+	}
+	return boundFilters, isDropped
+}
+
+// bindGroup binds the members of fg, where a dropped member is true. It
+// reports whether the whole group is true, and so is to be dropped too.
+// path locates the group within the ReportSpec, for error messages.
+func bindGroup(fg *FilterGroup, bound map[string][]string, path string) (*FilterGroup, bool, error) {
+	boundFilters, isDropped := bindFilters(fg.Filters, bound)
+	boundGroup := FilterGroup{Op: fg.Op, Filters: boundFilters}
+	for idx := range fg.Groups {
+		subGroup, isTrue, err := bindGroup(&fg.Groups[idx], bound, memberPath(path, "Groups", idx))
+		if err != nil {
+			return nil, false, err
+		}
+		if isTrue {
+			isDropped = true
+			continue
+		}
+		boundGroup.Groups = append(boundGroup.Groups, *subGroup)
+	}
+	if !isDropped {
+		return &boundGroup, false, nil
+	}
+
+	isEmpty := len(boundGroup.Filters) == 0 && len(boundGroup.Groups) == 0
+	op, _ := fg.groupOp()
+	switch {
+	case op == GroupOr:
+		return nil, true, nil
+	case op == GroupNot && isEmpty:
+		return nil, false, fmt.Errorf("Every member of the not group %s refers to a parameter without values", path)
+	}
+	return &boundGroup, isEmpty, nil
+}
@@ -0,0 +1,104 @@
+package repmeta
+
+import (
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+var (
+	regionFilter = FilterSpec{FldName: "region", Op: "in", Values: []string{"{{region}}"}}
+	amountFilter = FilterSpec{FldName: "amount", Op: "gt", Values: []string{"{{amount}}"}}
+	qtyFilter    = FilterSpec{FldName: "qty", Op: "lt", Values: []string{"3"}}
+)
+
+// paramReport is salesReport filtered by tree, with the optional
+// parameters region and amount
+func paramReport(tree *FilterGroup) *ReportSpec {
+	spec := salesReport()
+	spec.Filters = nil
+	spec.FilterTree = tree
+	spec.Parameters = []ParamSpec{
+		{ParamName: "region"},
+		{ParamName: "amount", ParamType: "currency"},
+	}
+	return spec
+}
+
+func TestBindGroup(t *testing.T) {
+	both := map[string][]string{"region": {"East", "West"}, "amount": {"1000"}}
+	regionOnly := map[string][]string{"region": {"East"}}
+	for _, tc := range []struct {
+		name   string
+		tree   FilterGroup
+		values map[string][]string
+		want   string // the WhereTerm of the bound FilterTree, empty for none
+	}{
+		{"and bound", FilterGroup{Filters: []FilterSpec{regionFilter, amountFilter}}, both,
+			`("region" in ('East', 'West')) and ("amount" > 1000)`},
+		{"and unbound", FilterGroup{Filters: []FilterSpec{regionFilter, amountFilter}}, regionOnly,
+			`"region" in ('East')`},
+		{"and none bound", FilterGroup{Filters: []FilterSpec{regionFilter, amountFilter}}, nil, ``},
+		{"or bound", FilterGroup{Op: GroupOr, Filters: []FilterSpec{regionFilter, amountFilter}}, both,
+			`("region" in ('East', 'West')) or ("amount" > 1000)`},
+		{"or unbound", FilterGroup{Op: GroupOr, Filters: []FilterSpec{regionFilter, amountFilter}}, regionOnly, ``},
+		{"not bound", FilterGroup{Op: GroupNot, Filters: []FilterSpec{regionFilter, qtyFilter}}, regionOnly,
+			`not (("region" in ('East')) and ("qty" < 3))`},
+		{"not unbound", FilterGroup{Op: GroupNot, Filters: []FilterSpec{amountFilter, qtyFilter}}, regionOnly,
+			`not ("qty" < 3)`},
+		{"nested bound", FilterGroup{Filters: []FilterSpec{qtyFilter}, Groups: []FilterGroup{
+			{Op: GroupOr, Filters: []FilterSpec{regionFilter, amountFilter}},
+			{Op: GroupNot, Filters: []FilterSpec{amountFilter}},
+		}}, both,
+			`("qty" < 3) and (("region" in ('East', 'West')) or ("amount" > 1000)) and (not ("amount" > 1000))`},
+		{"nested unbound", FilterGroup{Filters: []FilterSpec{qtyFilter}, Groups: []FilterGroup{
+			{Op: GroupOr, Filters: []FilterSpec{regionFilter, amountFilter}},
+			{Op: GroupNot, Filters: []FilterSpec{regionFilter, amountFilter}},
+		}}, regionOnly,
+			`("qty" < 3) and (not ("region" in ('East')))`},
+		{"nested none bound", FilterGroup{Op: GroupOr, Groups: []FilterGroup{
+			{Filters: []FilterSpec{regionFilter}},
+			{Filters: []FilterSpec{qtyFilter}},
+		}}, nil, ``},
+	} {
+		tree := tc.tree
+		boundSpec, err := BindParams(paramReport(&tree), tc.values)
+		if err != nil {
+			t.Errorf("%s: %s", tc.name, err)
+			continue
+		}
+		got := ""
+		if boundSpec.FilterTree != nil {
+			got, err = boundSpec.FilterTree.WhereTerm(&boundSpec.Dataset, zap.NewNop().Sugar())
+			if err != nil {
+				t.Errorf("%s: %s", tc.name, err)
+				continue
+			}
+		}
+		if got != tc.want {
+			t.Errorf("%s: %s, expected %s", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestBindGroupFails(t *testing.T) {
+	for _, tc := range []struct {
+		tree   FilterGroup
+		values map[string][]string
+		want   string
+	}{
+		{FilterGroup{Op: GroupNot, Filters: []FilterSpec{regionFilter, amountFilter}}, nil,
+			"Every member of the not group FilterTree refers to a parameter without values"},
+		{FilterGroup{Groups: []FilterGroup{{Op: GroupNot, Groups: []FilterGroup{{Filters: []FilterSpec{amountFilter}}}}}}, nil,
+			"Every member of the not group FilterTree.Groups[0] refers to a parameter without values"},
+		{FilterGroup{Filters: []FilterSpec{amountFilter}}, map[string][]string{"amount": {"12.34"}},
+			`Parameter "amount"`},
+	} {
+		tree := tc.tree
+		_, err := BindParams(paramReport(&tree), tc.values)
+		if err == nil || !strings.HasPrefix(err.Error(), tc.want) {
+			t.Errorf("%s: %v, expected %q", tree.String(), err, tc.want)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package repmeta
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	reptext "github.com/radiochild/utils/text"
+	"go.uber.org/zap"
+)
+
+// PickItem is one entry of a filter pick list
+type PickItem struct {
+	Value *DataVal
+	Name  string
+}
+
+// PickListOptions narrow a pick list
+type PickListOptions struct {
+	Prefix string // only labels starting with Prefix, ignoring case
+	Limit  int    // at most Limit items, all of them when 0
+}
+
+// pickListSpec is spec with the filters on the picked fields dropped, so
+// the pick list is narrowed by the report's other filters alone
+func pickListSpec(spec *ReportSpec, fldNames []string) *ReportSpec {
+	pickSpec := *spec
+	// the pick list is not ordered by the report
+	pickSpec.Groups = nil
+	pickSpec.Sorts = nil
+	pickSpec.Filters = withoutFields(spec.Filters, fldNames)
+	if spec.FilterTree != nil {
+		pickSpec.FilterTree = withoutFieldsGroup(spec.FilterTree, fldNames)
+	}
+	return &pickSpec
+}
+
+func withoutFields(allFilters []FilterSpec, fldNames []string) []FilterSpec {
+	kept := []FilterSpec{}
+	for _, filter := range allFilters {
+		if !containsString(fldNames, filter.FldName) {
+			kept = append(kept, filter)
+		}
+	}
+	return kept
+}
+
+func withoutFieldsGroup(fg *FilterGroup, fldNames []string) *FilterGroup {
+	kept := FilterGroup{Op: fg.Op, Filters: withoutFields(fg.Filters, fldNames)}
+	for idx := range fg.Groups {
+		kept.Groups = append(kept.Groups, *withoutFieldsGroup(&fg.Groups[idx], fldNames))
+	}
+	return &kept
+}
+
+// FormatPickListQuery renders a query for the distinct values of the
+// field fldName, with their labels when the field has a SelectName, in
+// label order. The report's filters on other fields are applied.
+func FormatPickListQuery(spec *ReportSpec, fldName string, pickOpts PickListOptions, opts QueryOptions, logger *zap.SugaredLogger) (string, []interface{}, error) {
+	_, pFld := spec.Dataset.FieldNamed(fldName)
+	if pFld == nil {
+		return "", nil, fmt.Errorf("Pick list field %q is not a field of dataset %q", fldName, spec.Dataset.DatasetName)
+	}
+	valueFld, nameFld := pFld.PickFields()
+	pickFlds := []string{fldName, valueFld, nameFld}
+	if err := checkIdents(&spec.Dataset, "Pick list", pickFlds); err != nil {
+		return "", nil, err
+	}
+
+	pickSpec := pickListSpec(spec, pickFlds)
+	// nulls are not offered, exists is rendered as "is null" so it is negated
+	pickSpec.Filters = append(pickSpec.Filters, FilterSpec{FldName: valueFld, Op: "exists", Options: []string{"not"}})
+	if len(pickOpts.Prefix) > 0 {
+		_, pName := spec.Dataset.FieldNamed(nameFld)
+		if pName.FldType != "text" {
+			return "", nil, fmt.Errorf("Prefix search requires a text field, %q is %s", nameFld, pName.FldType)
+		}
+		pickSpec.Filters = append(pickSpec.Filters, FilterSpec{FldName: nameFld, Op: "prefix", Values: []string{pickOpts.Prefix}})
+	}
+
+	opts.Keyset = false
+	opts.After = ""
+	qp, err := newQueryParts(pickSpec, opts)
+	if err != nil {
+		return "", nil, err
+	}
+	d := qp.d
+	table, err := qp.table(pickSpec, []string{valueFld, nameFld})
+	if err != nil {
+		return "", nil, err
+	}
+	where, err := qp.where(pickSpec, logger)
+	if err != nil {
+		return "", nil, err
+	}
+
+	selected := []string{valueFld}
+	order := []SortSpec{{FldName: nameFld}}
+	if nameFld != valueFld {
+		selected = append(selected, nameFld)
+		order = append(order, SortSpec{FldName: valueFld})
+	}
+	top, paging := "", ""
+	if pickOpts.Limit > 0 {
+		top, paging = formatOffset(d, 0, pickOpts.Limit, true)
+	}
+	selection := reptext.AppendText(top, strings.Join(selectSQLs(d, &spec.Dataset, selected), ", "))
+	suffix := reptext.AppendText(where, formatOrder(d, &spec.Dataset, order), paging)
+	qry := fmt.Sprintf("select distinct %s from %s %s", selection, table, suffix)
+	return qry, qp.values(), nil
+}
+
+// PickList queries db for the pick list of the field fldName, each value
+// typed as its field
+func PickList(ctx context.Context, db *sql.DB, spec *ReportSpec, fldName string, pickOpts PickListOptions, opts QueryOptions, logger *zap.SugaredLogger) ([]PickItem, error) {
+	opts.BindArgs = true
+	qry, args, err := FormatPickListQuery(spec, fldName, pickOpts, opts, logger)
+	if err != nil {
+		return nil, err
+	}
+	logger.Debugf("Running %s %v", qry, args)
+
+	rows, err := db.QueryContext(ctx, qry, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	_, pFld := spec.Dataset.FieldNamed(fldName)
+	valueFld, nameFld := pFld.PickFields()
+	_, pValue := spec.Dataset.FieldNamed(valueFld)
+	_, pName := spec.Dataset.FieldNamed(nameFld)
+	hasName := nameFld != valueFld
+
+	allItems := []PickItem{}
+	for rows.Next() {
+		var rawValue, rawName interface{}
+		ptrs := []interface{}{&rawValue}
+		if hasName {
+			ptrs = append(ptrs, &rawName)
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			return nil, err
+		}
+		value := NewDataValTyped(ToDataValType(pValue.FldType))
+		if err := setScanned(value, rawValue); err != nil {
+			return nil, fmt.Errorf("Pick list value of %q: %s", valueFld, err.Error())
+		}
+		item := PickItem{Value: value, Name: value.String()}
+		if hasName {
+			name := NewDataValTyped(ToDataValType(pName.FldType))
+			if err := setScanned(name, rawName); err != nil {
+				return nil, fmt.Errorf("Pick list label of %q: %s", nameFld, err.Error())
+			}
+			item.Name = name.String()
+		}
+		allItems = append(allItems, item)
+	}
+	return allItems, rows.Err()
+}
@@ -9,72 +9,251 @@ import (
 	"go.uber.org/zap"
 )
 
-func formatWhere(ds *DatasetSpec, filters []FilterSpec, logger *zap.SugaredLogger) string {
-	numFilters := len(filters)
-	if numFilters < 1 {
-		return ""
+// filterTerms renders the flat filters, anded with the filter tree.
+// args is nil when filter values are to be written as literals.
+func filterTerms(d Dialect, ds *DatasetSpec, root *FilterGroup, args *SQLArgs, lenient bool, logger *zap.SugaredLogger) ([]string, error) {
+	// the root's only group is the spec's FilterTree
+	flatFilters := FilterGroup{Filters: root.Filters}
+	allTerms, err := flatFilters.memberTerms(ds, d, args, lenient, "", logger)
+	if err != nil {
+		return nil, err
 	}
-	if numFilters == 1 {
-		term, err := filters[0].WhereTerm(ds, logger)
-		if err != nil {
-			logger.Warnf("%s", err.Error())
-			return ""
-		}
-		return fmt.Sprintf("where %s", term)
-	}
-
-	wrappedFilters := []string{}
-	for _, filter := range filters {
-		term, err := filter.WhereTerm(ds, logger)
+	for idx := range root.Groups {
+		term, err := root.Groups[idx].whereTerm(ds, d, args, lenient, "FilterTree", logger)
 		if err != nil {
+			if !lenient {
+				return nil, err
+			}
 			logger.Warnf("%s", err.Error())
 			continue
 		}
-		wrappedFilter := fmt.Sprintf("(%s)", term)
-		wrappedFilters = append(wrappedFilters, wrappedFilter)
+		if len(term) > 0 {
+			allTerms = append(allTerms, term)
+		}
 	}
-	numFilters = len(wrappedFilters)
-	if numFilters == 0 {
+	return allTerms, nil
+}
+
+func whereClause(allTerms []string) string {
+	switch len(allTerms) {
+	case 0:
 		return ""
+	case 1:
+		return fmt.Sprintf("where %s", allTerms[0])
 	}
-	if numFilters == 1 {
-		return fmt.Sprintf("where %s", wrappedFilters[0])
-	}
-	allFilters := strings.Join(wrappedFilters, " and ")
-	return fmt.Sprintf("where (%s)", allFilters)
+	return fmt.Sprintf("where (%s)", joinTerms(allTerms, GroupAnd))
 }
 
-func formatOrder(groups []string) string {
-	if len(groups) < 1 {
+func formatOrder(d Dialect, ds *DatasetSpec, allSorts []SortSpec) string {
+	if len(allSorts) < 1 {
 		return ""
 	}
-	allGroups := strings.Join(groups, ", ")
-	return fmt.Sprintf("order by %s", allGroups)
+	allTerms := []string{}
+	for _, ss := range allSorts {
+		allTerms = append(allTerms, d.OrderTerm(ds.fieldSQL(d, ss.FldName), ss.IsDesc(), ss.nulls()))
+	}
+	return fmt.Sprintf("order by %s", strings.Join(allTerms, ", "))
 }
 
-func formatOffset(page, maxRecs int) string {
+// returns the text following "select" and the text ending the query
+func formatOffset(d Dialect, page, maxRecs int, isOrdered bool) (string, string) {
 	if page < 0 {
-		return ""
+		return "", ""
 	}
 	startRec := page * maxRecs
 	// return fmt.Sprintf("offset %d limit %d", startRec, maxRecs)
-	return fmt.Sprintf("limit %d offset %d", maxRecs, startRec)
+	return d.Paging(maxRecs, startRec, isOrdered)
+}
+
+// Every column written into the query must be a declared field of the
+// dataset, so a crafted ReportSpec cannot smuggle SQL in through a name
+func checkIdents(ds *DatasetSpec, kind string, names []string) error {
+	for _, name := range names {
+		idx, _ := ds.FieldNamed(name)
+		if idx == -1 {
+			return fmt.Errorf("%s %q is not a field of dataset %q", kind, name, ds.DatasetName)
+		}
+	}
+	return nil
+}
+
+// QueryOptions select how FormatQueryWith renders a ReportSpec
+type QueryOptions struct {
+	Dialect  Dialect     // overrides spec.Dataset.Dialect when set
+	BindArgs bool        // placeholders instead of literal filter values
+	Dates    DateContext // resolves relative date filters
+	// skip filters that cannot be rendered, with a warning, instead of
+	// failing with a *FilterError. Skipping a filter widens the report.
+	Lenient bool
+	// page by seeking past the last row of the previous page rather than
+	// with an offset. Rows are ordered by the Groups, the Sorts and then
+	// the spec's KeyField, nulls sorting low unless a Sort places them,
+	// and After is the NextPageToken of the previous page.
+	Keyset bool
+	After  string
 }
 
+// FormatQuery returns "" when any filter of spec is invalid, rather than
+// a query for more rows than the report allows
 func FormatQuery(spec *ReportSpec, maxRecs int, logger *zap.SugaredLogger) string {
+	qry, _, err := FormatQueryWith(spec, maxRecs, QueryOptions{}, logger)
+	if err != nil {
+		logger.Errorf("%s", err.Error())
+		return ""
+	}
+	return qry
+}
+
+// FormatQueryArgs returns the query with positional placeholders ($1, $2, ...)
+// in place of filter values, along with the typed bind arguments
+func FormatQueryArgs(spec *ReportSpec, maxRecs int, logger *zap.SugaredLogger) (string, []interface{}) {
+	qry, args, err := FormatQueryWith(spec, maxRecs, QueryOptions{BindArgs: true}, logger)
+	if err != nil {
+		logger.Errorf("%s", err.Error())
+		return "", nil
+	}
+	return qry, args
+}
+
+// FormatQueryWith renders the query in the dialect chosen by opts or the
+// spec's Dataset. Bind arguments are only returned when opts.BindArgs is set.
+// An invalid filter fails the query with a *FilterError unless opts.Lenient.
+func FormatQueryWith(spec *ReportSpec, maxRecs int, opts QueryOptions, logger *zap.SugaredLogger) (string, []interface{}, error) {
+	qp, err := newQueryParts(spec, opts)
+	if err != nil {
+		return "", nil, err
+	}
+	qry, err := qp.formatQuery(spec, maxRecs, logger)
+	if err != nil {
+		return "", nil, err
+	}
+	return qry, qp.values(), nil
+}
+
+// queryParts holds what every query of a ReportSpec is rendered with
+type queryParts struct {
+	d       Dialect
+	args    *SQLArgs     // nil when filter values are written as literals
+	root    *FilterGroup // the filters of the spec, relative dates resolved
+	lenient bool
+	order   []SortSpec // the order of the rows
+	after   *pageKey   // keyset paging resumes after this row
+}
+
+// dialect is the Dialect of opts, or else of the dataset
+func (opts QueryOptions) dialect(ds *DatasetSpec) (Dialect, error) {
+	if opts.Dialect != nil {
+		return opts.Dialect, nil
+	}
+	return ds.SQLDialect()
+}
+
+func newQueryParts(spec *ReportSpec, opts QueryOptions) (*queryParts, error) {
+	d, err := opts.dialect(&spec.Dataset)
+	if err != nil {
+		return nil, err
+	}
+	if err := spec.Dataset.checkExprs(d); err != nil {
+		return nil, err
+	}
+	qp := queryParts{d: d, lenient: opts.Lenient}
+	if opts.BindArgs {
+		qp.args = NewSQLArgs(d)
+	}
+	root := spec.rootFilterGroup()
+	if err := root.checkBound(); err != nil {
+		return nil, err
+	}
+	qp.root = root.ResolveDates(&spec.Dataset, opts.Dates)
+
+	qp.order = spec.OrderSpecs()
+	if opts.Keyset {
+		var err error
+		qp.order, err = spec.keysetOrder()
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, ss := range qp.order {
+		if err := ss.check(); err != nil {
+			return nil, err
+		}
+	}
+	if len(opts.After) > 0 {
+		if !opts.Keyset {
+			return nil, fmt.Errorf("A page token requires keyset paging")
+		}
+		var err error
+		qp.after, err = decodePageToken(sortFldNames(qp.order), opts.After)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &qp, nil
+}
+
+// values are the bind arguments of the query, nil when there are none
+func (qp *queryParts) values() []interface{} {
+	if qp.args == nil {
+		return nil
+	}
+	return qp.args.Values
+}
+
+// table renders the from clause, joining what fldNames, the filters and
+// the order of the rows need
+func (qp *queryParts) table(spec *ReportSpec, fldNames []string) (string, error) {
+	allFlds := append([]string{}, fldNames...)
+	allFlds = append(allFlds, qp.root.FldNames()...)
+	allFlds = append(allFlds, sortFldNames(qp.order)...)
+	return spec.Dataset.fromSQL(qp.d, allFlds)
+}
+
+func (qp *queryParts) where(spec *ReportSpec, logger *zap.SugaredLogger) (string, error) {
+	allTerms, err := filterTerms(qp.d, &spec.Dataset, qp.root, qp.args, qp.lenient, logger)
+	if err != nil {
+		return "", err
+	}
+	if qp.after != nil {
+		seek, err := qp.seekTerm(spec)
+		if err != nil {
+			return "", err
+		}
+		allTerms = append(allTerms, seek)
+	}
+	return whereClause(allTerms), nil
+}
+
+func (qp *queryParts) formatQuery(spec *ReportSpec, maxRecs int, logger *zap.SugaredLogger) (string, error) {
+	d := qp.d
 	page := 0
 	if maxRecs < 0 {
 		page = -1
 	}
 
-	table := spec.Dataset.ViewName
-	colNames := ColSpecFldNames(spec.Columns)
-	allCols := append(spec.ExtraColumns, colNames...)
-	fldList := strings.Join(allCols, ", ")
-	where := formatWhere(&spec.Dataset, spec.Filters, logger)
-	order := formatOrder(spec.Groups)
-	paging := formatOffset(page, maxRecs)
+	allCols := spec.FieldColumns()
+	if err := checkIdents(&spec.Dataset, "Column", allCols); err != nil {
+		return "", err
+	}
+	table, err := qp.table(spec, allCols)
+	if err != nil {
+		return "", err
+	}
+	if err := checkIdents(&spec.Dataset, "Group", spec.Groups); err != nil {
+		return "", err
+	}
+	fldList := strings.Join(selectSQLs(d, &spec.Dataset, allCols), ", ")
+	where, err := qp.where(spec, logger)
+	if err != nil {
+		return "", err
+	}
+	if err := checkIdents(&spec.Dataset, "Order", sortFldNames(qp.order)); err != nil {
+		return "", err
+	}
+	order := formatOrder(d, &spec.Dataset, qp.order)
+	top, paging := formatOffset(d, page, maxRecs, order != "")
+	selection := reptext.AppendText(top, fldList)
 	suffix := reptext.AppendText(where, order, paging)
-	qry := fmt.Sprintf("select %s from %s %s", fldList, table, suffix)
-	return qry
+	qry := fmt.Sprintf("select %s from %s %s", selection, table, suffix)
+	return qry, nil
 }
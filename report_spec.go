@@ -24,9 +24,15 @@ import (
 // };
 // ------------------------------------------------------------
 
+// ColumnSpec names a field of the dataset, or when it has an Expr, a
+// column computed from the other columns, e.g. "profit / revenue * 100".
+// A computed column is evaluated on each detail row and again on each
+// level's totals, so it is never totalled itself.
 type ColumnSpec struct {
 	FldName  string
 	CalcType string
+	Expr     string // + - * / over numbers and other columns
+	FldType  string // the type of a computed column, float when empty
 }
 
 type ReportSpec struct {
@@ -34,7 +40,13 @@ type ReportSpec struct {
 	Columns      []ColumnSpec
 	ExtraColumns []string
 	Groups       []string
+	Sorts        []SortSpec // order the rows within their groups
 	Filters      []FilterSpec
+	FilterTree   *FilterGroup      // anded with Filters
+	GroupFilters []GroupFilterSpec // test the totals of groups
+	TopGroups    []TopGroupSpec    // keep the groups with the largest totals
+	Parameters   []ParamSpec       // see BindParams
+	KeyField     string            // a field unique to each row, for keyset paging
 }
 
 func (cs ColumnSpec) String() string {
@@ -45,6 +57,30 @@ func (cs ColumnSpec) String() string {
 	return fmt.Sprintf("%s(%s)", fldName, cs.CalcType)
 }
 
+// Calc is the CalcType used for the column's totals. Columns without a
+// CalcType are only summed when their field CanCalc, so ids, years and
+// the like are left blank.
+func (cs ColumnSpec) Calc(fld *FieldSpec) string {
+	if len(cs.CalcType) > 0 {
+		return cs.CalcType
+	}
+	if fld != nil && fld.CanCalc {
+		return CalcSum
+	}
+	return CalcNone
+}
+
+func (cs ColumnSpec) IsComputed() bool {
+	return len(cs.Expr) > 0
+}
+
+func (cs ColumnSpec) computedType() DataValType {
+	if len(cs.FldType) == 0 {
+		return DVFloat
+	}
+	return ToDataValType(cs.FldType)
+}
+
 func ColSpecFldNames(allColumns []ColumnSpec) []string {
 	fldNames := []string{}
 	for _, cs := range allColumns {
@@ -75,6 +111,16 @@ func ReadReportSpec(filename string) (*ReportSpec, error) {
 			extraColumns = append(extraColumns, group)
 		}
 	}
+	// the key and sorts are needed to build a page token from the last row
+	isKeyed := len(spec.KeyField) > 0
+	if isKeyed {
+		keyFlds := append(sortFldNames(spec.Sorts), spec.KeyField)
+		for _, fldName := range keyFlds {
+			if !allCols.Contains(fldName) && !containsString(extraColumns, fldName) {
+				extraColumns = append(extraColumns, fldName)
+			}
+		}
+	}
 	spec.ExtraColumns = extraColumns
 
 	return &spec, err2
@@ -97,10 +143,78 @@ func ShowReportSpec(spec *ReportSpec, logger *zap.SugaredLogger) {
 	logger.Infof("")
 	logger.Infof("Groups:")
 	logger.Infof("%v", spec.Groups)
+	if len(spec.Sorts) > 0 {
+		logger.Infof("")
+		logger.Infof("Sorts:")
+		logger.Infof("%v", spec.Sorts)
+	}
 
 	logger.Infof("")
 	logger.Infof("Filters:")
 	logger.Infof("%s", spec.Filters)
+	if spec.FilterTree != nil {
+		logger.Infof("%s", spec.FilterTree)
+	}
+	if len(spec.GroupFilters) > 0 {
+		logger.Infof("")
+		logger.Infof("Group Filters:")
+		logger.Infof("%v", spec.GroupFilters)
+	}
+	if len(spec.TopGroups) > 0 {
+		logger.Infof("")
+		logger.Infof("Top Groups:")
+		logger.Infof("%v", spec.TopGroups)
+	}
+
+	if len(spec.Parameters) > 0 {
+		logger.Infof("")
+		logger.Infof("Parameters:")
+		logger.Infof("%v", spec.Parameters)
+	}
+}
+
+// AllColumns lists the columns of a DataRow in order, ExtraColumns first
+func (spec *ReportSpec) AllColumns() []string {
+	allCols := []string{}
+	allCols = append(allCols, spec.ExtraColumns...)
+	return append(allCols, ColSpecFldNames(spec.Columns)...)
+}
+
+// computedAt returns the ColumnSpec of the column at colIdx of a DataRow
+// when it is computed, otherwise nil
+func (spec *ReportSpec) computedAt(colIdx int) *ColumnSpec {
+	specIdx := colIdx - len(spec.ExtraColumns)
+	if specIdx < 0 || specIdx >= len(spec.Columns) || !spec.Columns[specIdx].IsComputed() {
+		return nil
+	}
+	return &spec.Columns[specIdx]
+}
+
+// FieldColumns lists the columns read from the dataset, AllColumns
+// without the computed ones
+func (spec *ReportSpec) FieldColumns() []string {
+	allCols := []string{}
+	allCols = append(allCols, spec.ExtraColumns...)
+	for _, cs := range spec.Columns {
+		if !cs.IsComputed() {
+			allCols = append(allCols, cs.FldName)
+		}
+	}
+	return allCols
+}
+
+// ColumnCalcs returns the CalcType of each column of a DataRow.
+// ExtraColumns are only there for grouping and are never totalled.
+func (spec *ReportSpec) ColumnCalcs() []string {
+	allCalcs := []string{}
+	for range spec.ExtraColumns {
+		allCalcs = append(allCalcs, CalcNone)
+	}
+	for _, cs := range spec.Columns {
+		_, pFld := spec.Dataset.FieldNamed(cs.FldName)
+		allCalcs = append(allCalcs, cs.Calc(pFld))
+	}
+	return allCalcs
 }
 
 func (spec *ReportSpec) ColumnIndex(fld *FieldSpec) int {
@@ -0,0 +1,436 @@
+package repmeta
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	reptext "github.com/radiochild/utils/text"
+	"go.uber.org/zap"
+)
+
+// FormatRollupQuery renders a query for the totals of spec alone, without
+// its detail rows: one row for every group at every level and one for the
+// grand total, from a group by rollup. Dialects without a rollup union a
+// grouped select for each level instead.
+//
+// Each row holds the Groups, a total for every column with a calc, the
+// count of rows totalled, and then a flag for each group that is 1 when
+// the group has been rolled up.
+func FormatRollupQuery(spec *ReportSpec, opts QueryOptions, logger *zap.SugaredLogger) (string, []interface{}, error) {
+	qp, err := newQueryParts(spec, opts)
+	if err != nil {
+		return "", nil, err
+	}
+	qry, err := qp.formatRollup(spec, logger)
+	if err != nil {
+		return "", nil, err
+	}
+	return qry, qp.values(), nil
+}
+
+func (qp *queryParts) formatRollup(spec *ReportSpec, logger *zap.SugaredLogger) (string, error) {
+	d := qp.d
+	if len(spec.TopGroups) > 0 {
+		return "", fmt.Errorf("Top groups are totalled from the detail rows, they cannot be written from a rollup query")
+	}
+	if err := checkIdents(&spec.Dataset, "Column", spec.FieldColumns()); err != nil {
+		return "", err
+	}
+	table, err := qp.table(spec, spec.FieldColumns())
+	if err != nil {
+		return "", err
+	}
+	if err := checkIdents(&spec.Dataset, "Group", spec.Groups); err != nil {
+		return "", err
+	}
+	ds := &spec.Dataset
+	groupCols := fieldSQLs(d, ds, spec.Groups)
+	allTotals := rollupTotals(d, spec)
+
+	rollup := d.GroupByRollup(groupCols)
+	if rollup != "" || len(groupCols) == 0 {
+		where, err := qp.where(spec, logger)
+		if err != nil {
+			return "", err
+		}
+		selection := selectSQLs(d, ds, spec.Groups)
+		selection = append(selection, allTotals...)
+		selection = append(selection, "count(*)")
+		for _, col := range groupCols {
+			selection = append(selection, fmt.Sprintf("grouping(%s)", col))
+		}
+		if len(groupCols) == 0 {
+			rollup = ""
+		}
+		having, err := qp.rollupHaving(spec, groupCols)
+		if err != nil {
+			return "", err
+		}
+		suffix := reptext.AppendText(reptext.AppendText(where, rollup), having)
+		return fmt.Sprintf("select %s from %s %s", strings.Join(selection, ", "), table, suffix), nil
+	}
+
+	// one grouped select for each level, every one binding the filter
+	// values again
+	allSelects := []string{}
+	for level := len(groupCols); level >= 0; level-- {
+		selection := []string{}
+		for idx, group := range spec.Groups {
+			if idx < level {
+				selection = append(selection, ds.selectSQL(d, group))
+			} else {
+				selection = append(selection, "null")
+			}
+		}
+		selection = append(selection, allTotals...)
+		selection = append(selection, "count(*)")
+		for idx := range groupCols {
+			if idx < level {
+				selection = append(selection, "0")
+			} else {
+				selection = append(selection, "1")
+			}
+		}
+		where, err := qp.where(spec, logger)
+		if err != nil {
+			return "", err
+		}
+		groupBy := ""
+		if level > 0 {
+			groupBy = fmt.Sprintf("group by %s", strings.Join(groupCols[:level], ", "))
+		}
+		allTerms, err := qp.havingTerms(spec, level)
+		if err != nil {
+			return "", err
+		}
+		if len(allTerms) > 0 {
+			groupBy = fmt.Sprintf("%s having %s", groupBy, strings.Join(allTerms, " and "))
+		}
+		suffix := reptext.AppendText(where, groupBy)
+		allSelects = append(allSelects, fmt.Sprintf("select %s from %s %s", strings.Join(selection, ", "), table, suffix))
+	}
+	return strings.Join(allSelects, " union all "), nil
+}
+
+// rollupHaving renders a having clause applying the GroupFilters of each
+// level only to the rows of that level. The rows of the groups below one
+// that is left out are removed by WriteRollup.
+func (qp *queryParts) rollupHaving(spec *ReportSpec, groupCols []string) (string, error) {
+	allConds := []string{}
+	for level := 1; level <= len(groupCols); level++ {
+		allTerms, err := qp.havingTerms(spec, level)
+		if err != nil {
+			return "", err
+		}
+		if len(allTerms) == 0 {
+			continue
+		}
+		// any row but one of this level passes
+		otherLevels := []string{fmt.Sprintf("grouping(%s) = 1", groupCols[level-1])}
+		if level < len(groupCols) {
+			otherLevels = append(otherLevels, fmt.Sprintf("grouping(%s) = 0", groupCols[level]))
+		}
+		allTerms = append(otherLevels, fmt.Sprintf("(%s)", strings.Join(allTerms, " and ")))
+		allConds = append(allConds, fmt.Sprintf("(%s)", strings.Join(allTerms, " or ")))
+	}
+	if len(allConds) == 0 {
+		return "", nil
+	}
+	return fmt.Sprintf("having %s", strings.Join(allConds, " and ")), nil
+}
+
+// rollupColumns lists the index within a DataRow of every column that is
+// totalled, the columns selected by rollupTotals
+func rollupColumns(spec *ReportSpec) []int {
+	colIdxs := []int{}
+	allCalcs := spec.ColumnCalcs()
+	for colIdx, column := range spec.AllColumns() {
+		_, pFld := spec.Dataset.FieldNamed(column)
+		if pFld == nil || spec.computedAt(colIdx) != nil {
+			continue
+		}
+		if CalcResultType(allCalcs[colIdx], ToDataValType(pFld.FldType)) != DVNone {
+			colIdxs = append(colIdxs, colIdx)
+		}
+	}
+	return colIdxs
+}
+
+func rollupTotals(d Dialect, spec *ReportSpec) []string {
+	allTotals := []string{}
+	allCols := spec.AllColumns()
+	allCalcs := spec.ColumnCalcs()
+	for _, colIdx := range rollupColumns(spec) {
+		_, pFld := spec.Dataset.FieldNamed(allCols[colIdx])
+		allTotals = append(allTotals, totalSQL(d, &spec.Dataset, allCalcs[colIdx], pFld))
+	}
+	return allTotals
+}
+
+// totalSQL is the aggregate matching an Aggregate of calc
+func totalSQL(d Dialect, ds *DatasetSpec, calc string, fld *FieldSpec) string {
+	col := ds.fieldSQL(d, fld.FldName)
+	switch calc {
+	case CalcSum:
+		return fmt.Sprintf("sum(%s)", col)
+	case CalcAvg:
+		// some databases average integers with integer division
+		if fld.FldType != "float" {
+			return fmt.Sprintf("avg(%s * 1.0)", col)
+		}
+		return fmt.Sprintf("avg(%s)", col)
+	case CalcMin:
+		return fmt.Sprintf("min(%s)", col)
+	case CalcMax:
+		return fmt.Sprintf("max(%s)", col)
+	case CalcCount:
+		return "count(*)"
+	case CalcCountNonNull:
+		return fmt.Sprintf("count(%s)", col)
+	}
+	return "null"
+}
+
+// rollupRow is one row of a rollup query
+type rollupRow struct {
+	level  int     // the number of groups not rolled up, 0 for the grand total
+	keys   DataRow // the value of each group
+	count  int64
+	totals *DataRow // laid out as a DataRow of the report
+}
+
+// WriteRollup writes the rows of a FormatRollupQuery through rW as SUM
+// rows, children before their parents, followed by the grand total.
+// It returns the number of rows totalled.
+func WriteRollup(rows *sql.Rows, spec *ReportSpec, rW *ReportWriter) (int64, error) {
+	d, err := spec.Dataset.SQLDialect()
+	if err != nil {
+		return 0, err
+	}
+	return writeRollup(rows, spec, rW, d)
+}
+
+// d is the Dialect the query was run with, which places the null groups
+func writeRollup(rows *sql.Rows, spec *ReportSpec, rW *ReportWriter, d Dialect) (int64, error) {
+	allRows, err := readRollup(rows, spec)
+	if err != nil {
+		return 0, err
+	}
+	allRows = rW.filterRollup(allRows)
+	sortRollup(allRows, d)
+
+	numRows := int64(0)
+	for _, row := range allRows {
+		name := ""
+		if row.level > 0 {
+			name = row.keys[row.level-1].String()
+		} else {
+			numRows = row.count
+		}
+		rW.WriteTotals(row.level, name, row.count, row.totals)
+		if err := rW.Err(); err != nil {
+			return numRows, err
+		}
+	}
+	return numRows, nil
+}
+
+// RunRollup writes the summary of spec through rW from a single rollup
+// query, instead of totalling every detail row as Run does, and closes rW,
+// or aborts it when the report fails
+func RunRollup(ctx context.Context, db *sql.DB, spec *ReportSpec, rW *ReportWriter, opts QueryOptions) (numRows int64, err error) {
+	defer finishWriter(rW, &err)
+
+	opts.BindArgs = true
+	d, err := opts.dialect(&spec.Dataset)
+	if err != nil {
+		return 0, err
+	}
+	qry, args, err := FormatRollupQuery(spec, opts, rW.logger)
+	if err != nil {
+		return 0, err
+	}
+	rW.logger.Debugf("Running %s %v", qry, args)
+
+	rows, err := db.QueryContext(ctx, qry, args...)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	numRows, err = writeRollup(rows, spec, rW, d)
+	if err != nil {
+		return numRows, err
+	}
+	rW.logger.Infof("Report complete: %d rows", numRows)
+	return numRows, nil
+}
+
+func readRollup(rows *sql.Rows, spec *ReportSpec) ([]*rollupRow, error) {
+	numGroups := len(spec.Groups)
+	totalIdxs := rollupColumns(spec)
+	numCols := 2*numGroups + len(totalIdxs) + 1
+	raw := make([]interface{}, numCols)
+	ptrs := make([]interface{}, numCols)
+	for idx := range raw {
+		ptrs[idx] = &raw[idx]
+	}
+
+	allRows := []*rollupRow{}
+	for rows.Next() {
+		if err := rows.Scan(ptrs...); err != nil {
+			return nil, err
+		}
+		row, err := newRollupRow(spec, totalIdxs, raw)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to read totals row %d: %s", len(allRows)+1, err.Error())
+		}
+		allRows = append(allRows, row)
+	}
+	return allRows, rows.Err()
+}
+
+func newRollupRow(spec *ReportSpec, totalIdxs []int, raw []interface{}) (*rollupRow, error) {
+	numGroups := len(spec.Groups)
+	row := rollupRow{}
+
+	allCalcs := spec.ColumnCalcs()
+	var totals DataRow
+	for colIdx, column := range spec.AllColumns() {
+		// computed columns are set by WriteTotals
+		if spec.computedAt(colIdx) != nil {
+			totals = append(totals, NewDVNone())
+			continue
+		}
+		_, pFld := spec.Dataset.FieldNamed(column)
+		totals = append(totals, NewDataValTyped(CalcResultType(allCalcs[colIdx], ToDataValType(pFld.FldType))))
+	}
+	row.totals = &totals
+
+	pos := 0
+	for _, group := range spec.Groups {
+		_, pFld := spec.Dataset.FieldNamed(group)
+		key := NewDataValTyped(ToDataValType(pFld.FldType))
+		if err := setScanned(key, raw[pos]); err != nil {
+			return nil, fmt.Errorf("Group %q: %s", group, err.Error())
+		}
+		row.keys = append(row.keys, key)
+		pos++
+	}
+	for _, colIdx := range totalIdxs {
+		if err := setScanned(totals[colIdx], raw[pos]); err != nil {
+			return nil, fmt.Errorf("Total of %q: %s", spec.AllColumns()[colIdx], err.Error())
+		}
+		pos++
+	}
+	count := NewDVInt(0)
+	if err := setScanned(count, raw[pos]); err != nil {
+		return nil, fmt.Errorf("Count: %s", err.Error())
+	}
+	row.count, _ = count.Int64()
+	pos++
+
+	// rolled up groups are always the last ones
+	row.level = numGroups
+	for idx := 0; idx < numGroups; idx++ {
+		flag := NewDVInt(0)
+		if err := setScanned(flag, raw[pos+idx]); err != nil {
+			return nil, fmt.Errorf("Grouping flag: %s", err.Error())
+		}
+		if isRolled, _ := flag.Int64(); isRolled != 0 && idx < row.level {
+			row.level = idx
+		}
+	}
+	return &row, nil
+}
+
+// filterRollup drops the rows of groups failing their GroupFilters, along
+// with the rows of every group below them. A group may already have been
+// left out by the having clause, so only the groups seen to pass are kept.
+func (rW *ReportWriter) filterRollup(allRows []*rollupRow) []*rollupRow {
+	passed := map[string]bool{}
+	for _, row := range allRows {
+		pred := rW.levelFilter(row.level)
+		if pred == nil || row.level == 0 {
+			continue
+		}
+		computeColumns(rW.computed, row.totals)
+		if pred(row.totals) {
+			passed[row.keyPrefix(row.level)] = true
+		}
+	}
+	keptRows := []*rollupRow{}
+	for _, row := range allRows {
+		isKept := true
+		for level := 1; level <= row.level; level++ {
+			if rW.levelFilter(level) != nil && !passed[row.keyPrefix(level)] {
+				isKept = false
+				break
+			}
+		}
+		if isKept {
+			keptRows = append(keptRows, row)
+		}
+	}
+	return keptRows
+}
+
+// keyPrefix identifies the group of the row at level, one of its own
+// or of its parents
+func (row *rollupRow) keyPrefix(level int) string {
+	allKeys := []string{fmt.Sprintf("%d", level)}
+	for _, key := range row.keys[:level] {
+		allKeys = append(allKeys, fmt.Sprintf("%t:%s", key.IsNull(), key.String()))
+	}
+	return strings.Join(allKeys, "\x00")
+}
+
+// sortRollup orders the rows as the footers of a detail report would be
+// written: by group, each group's subtotals before its own. Null groups
+// are placed as d places them in the detail query's order by.
+func sortRollup(allRows []*rollupRow, d Dialect) {
+	groupKey := sortKey{nullsLast: SortSpec{}.nullsLast(d)}
+	sort.SliceStable(allRows, func(i, j int) bool {
+		rowI, rowJ := allRows[i], allRows[j]
+		minLevel := rowI.level
+		if rowJ.level < minLevel {
+			minLevel = rowJ.level
+		}
+		for idx := 0; idx < minLevel; idx++ {
+			cmp := groupKey.compare(rowI.keys[idx], rowJ.keys[idx])
+			if cmp != 0 {
+				return cmp < 0
+			}
+		}
+		return rowI.level > rowJ.level
+	})
+}
+
+// setScanned assigns a value scanned into an interface{}. A currency
+// total may come back as a float or as decimal text, still in pennies,
+// and an average holds a fraction of a penny that is rounded as
+// Aggregate rounds it.
+func setScanned(dv *DataVal, raw interface{}) error {
+	if bytes, ok := raw.([]byte); ok {
+		raw = string(bytes)
+	}
+	if dv.Typ != DVCurrency {
+		return dv.SetValue(raw)
+	}
+	switch val := raw.(type) {
+	case float64:
+		raw = math.Round(val)
+	case string:
+		pennies, err := strconv.ParseFloat(strings.TrimSpace(val), 64)
+		if err != nil {
+			return fmt.Errorf("Value %q is not a valid currency", val)
+		}
+		raw = math.Round(pennies)
+	}
+	return dv.SetValue(raw)
+}
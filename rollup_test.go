@@ -0,0 +1,90 @@
+package repmeta
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+// runTotals runs spec in detail mode, or from a rollup query, and reads
+// its totals
+func runTotals(t *testing.T, spec *ReportSpec, isRollup bool) []ReportRow {
+	t.Helper()
+	db := openSalesDB(t)
+	ctx := context.Background()
+	opts := QueryOptions{Dialect: SQLite{}}
+
+	var b bytes.Buffer
+	rW := NewReportWriter(zap.NewNop().Sugar(), &b, OTJSON, "sales", true, spec, nil, "")
+	var err error
+	if isRollup {
+		_, err = RunRollup(ctx, db, spec, rW, opts)
+	} else {
+		_, err = RunWith(ctx, db, spec, rW, opts)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return summaryRows(t, &b)
+}
+
+func TestRunRollupSQLite(t *testing.T) {
+	got := runTotals(t, salesReport(), true)
+	if !reflect.DeepEqual(got, salesTotals) {
+		t.Errorf("Totals %v, expected %v", got, salesTotals)
+	}
+
+	// region is null for one row, which SQLite orders first
+	spec := salesReport()
+	spec.Filters = nil
+	want := runTotals(t, spec, false)
+	if len(want) != 4 || want[0].LevelName != "" || want[0].LevelCount != 1 {
+		t.Fatalf("Detail totals %v, expected a null region first", want)
+	}
+	if got := runTotals(t, spec, true); !reflect.DeepEqual(got, want) {
+		t.Errorf("Rollup totals %v, expected %v", got, want)
+	}
+
+	spec.Groups = []string{"region", "customer"}
+	spec.ExtraColumns = []string{"region", "customer"}
+	want = runTotals(t, spec, false)
+	if got := runTotals(t, spec, true); !reflect.DeepEqual(got, want) {
+		t.Errorf("Rollup totals %v, expected %v", got, want)
+	}
+}
+
+// null groups follow the detail query's order by, which leaves their
+// placement to the database
+func TestSortRollupPlacesNulls(t *testing.T) {
+	newRow := func(level int, region interface{}) *rollupRow {
+		key := NewDataValTyped(DVText)
+		if err := key.SetValue(region); err != nil {
+			t.Fatal(err)
+		}
+		return &rollupRow{level: level, keys: DataRow{key}}
+	}
+	for _, tc := range []struct {
+		d    Dialect
+		want []string
+	}{
+		{PostgreSQL{}, []string{"East", "West", "", "Grand Totals"}},
+		{SQLite{}, []string{"", "East", "West", "Grand Totals"}},
+	} {
+		allRows := []*rollupRow{newRow(0, nil), newRow(1, "West"), newRow(1, nil), newRow(1, "East")}
+		sortRollup(allRows, tc.d)
+		got := []string{}
+		for _, row := range allRows {
+			name := "Grand Totals"
+			if row.level > 0 {
+				name = row.keys[0].String()
+			}
+			got = append(got, name)
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: %v, expected %v", tc.d.Name(), got, tc.want)
+		}
+	}
+}
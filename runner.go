@@ -0,0 +1,86 @@
+package repmeta
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// Run queries db for the rows of spec and writes them through rW, then
+// writes the closing footers and grand totals and closes rW.
+// It returns the number of rows read.
+func Run(ctx context.Context, db *sql.DB, spec *ReportSpec, rW *ReportWriter) (int64, error) {
+	return RunWith(ctx, db, spec, rW, QueryOptions{})
+}
+
+// RunWith is Run with the dialect chosen by opts. Filter values are
+// always passed as bind arguments.
+func RunWith(ctx context.Context, db *sql.DB, spec *ReportSpec, rW *ReportWriter, opts QueryOptions) (int64, error) {
+	src := &SQLSource{DB: db, Options: opts}
+	return RunSource(ctx, src, spec, rW)
+}
+
+// RunSource writes every row of src through rW, then writes the closing
+// footers and grand totals and closes rW. When the report fails, rW is
+// aborted instead.
+func RunSource(ctx context.Context, src DataSource, spec *ReportSpec, rW *ReportWriter) (numRows int64, err error) {
+	defer finishWriter(rW, &err)
+
+	rows, err := src.Rows(ctx, spec, rW.logger)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	numRows, err = writeRows(rows, rW)
+	if err != nil {
+		return numRows, err
+	}
+	rW.logger.Infof("Report complete: %d rows", numRows)
+	return numRows, nil
+}
+
+// finishWriter closes rW, or aborts it when *err is set or closing fails,
+// so that no output, e.g. an S3 upload, is left open. A failure to close
+// is kept in *err, a failure to abort is logged.
+func finishWriter(rW *ReportWriter, err *error) {
+	if *err == nil {
+		*err = rW.Close()
+		if *err == nil {
+			return
+		}
+	}
+	if abortErr := rW.Abort(); abortErr != nil {
+		rW.logger.Errorf("Unable to abort the report: %s", abortErr.Error())
+	}
+}
+
+// WriteRows scans every row into a DataRow for rW, and finishes the
+// report once the rows are exhausted
+func WriteRows(rows *sql.Rows, spec *ReportSpec, rW *ReportWriter) (int64, error) {
+	dR, err := NewDataRow(spec)
+	if err != nil {
+		return 0, err
+	}
+	return writeRows(newSQLRows(rows, spec, dR), rW)
+}
+
+func writeRows(rows RowIterator, rW *ReportWriter) (int64, error) {
+	if err := rW.Err(); err != nil {
+		return 0, err
+	}
+	numRows := int64(0)
+	for rows.Next() {
+		numRows++
+		rW.HandleDataRow(rows.Row())
+		if err := rW.Err(); err != nil {
+			return numRows, err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return numRows, fmt.Errorf("Unable to read row %d: %s", numRows+1, err.Error())
+	}
+
+	err := rW.Finish()
+	return numRows, err
+}
@@ -0,0 +1,252 @@
+package repmeta
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+
+	"github.com/jackc/pgx"
+	"github.com/jackc/pgx/pgtype"
+	"go.uber.org/zap"
+)
+
+// PgxQuerier is satisfied by *pgx.Conn and *pgx.ConnPool
+type PgxQuerier interface {
+	QueryEx(ctx context.Context, sql string, options *pgx.QueryExOptions, args ...interface{}) (*pgx.Rows, error)
+}
+
+// pgxColumn scans one result column into a pgtype value that is reused
+// for every row, then converts it into the column's DataVal
+type pgxColumn struct {
+	name  string
+	value interface{}
+}
+
+// PgxSource reads the rows of a ReportSpec over a native pgx connection.
+// Rows are read with the binary protocol straight into pgtype values,
+// skipping database/sql.
+type PgxSource struct {
+	DB    PgxQuerier
+	Dates DateContext // resolves relative date filters
+}
+
+// RunPgx is Run over a native pgx connection
+func RunPgx(ctx context.Context, db PgxQuerier, spec *ReportSpec, rW *ReportWriter) (int64, error) {
+	return RunSource(ctx, &PgxSource{DB: db}, spec, rW)
+}
+
+func (src *PgxSource) Rows(ctx context.Context, spec *ReportSpec, logger *zap.SugaredLogger) (RowIterator, error) {
+	dR, err := NewDataRow(spec)
+	if err != nil {
+		return nil, err
+	}
+	opts := QueryOptions{Dialect: PostgreSQL{}, BindArgs: true, Dates: src.Dates}
+	qry, args, err := FormatQueryWith(spec, -1, opts, logger)
+	if err != nil {
+		return nil, err
+	}
+	logger.Debugf("Running %s %v", qry, args)
+
+	rows, err := src.DB.QueryEx(ctx, qry, nil, args...)
+	if err != nil {
+		return nil, err
+	}
+	colIdxs := scannedColumns(spec, dR)
+	cols, err := newPgxColumns(rows.FieldDescriptions())
+	if err == nil && len(cols) != len(colIdxs) {
+		err = fmt.Errorf("Query returned %d columns, expected %d", len(cols), len(colIdxs))
+	}
+	if err != nil {
+		rows.Close()
+		return nil, err
+	}
+	it := &pgxRows{rows: rows, dR: dR, cols: cols, colIdxs: colIdxs}
+	for _, col := range cols {
+		it.targets = append(it.targets, col.value)
+	}
+	return it, nil
+}
+
+// scannedColumns lists the DataRow indexes read from the query, in the
+// order of ScanPointers. Computed columns are left to ReportWriter.
+func scannedColumns(spec *ReportSpec, dR *DataRow) []int {
+	var colIdxs []int
+	for colIdx := range *dR {
+		if spec.computedAt(colIdx) == nil {
+			colIdxs = append(colIdxs, colIdx)
+		}
+	}
+	return colIdxs
+}
+
+// pgxRows scans into the same pgtype values and DataRow for every row
+type pgxRows struct {
+	rows    *pgx.Rows
+	dR      *DataRow
+	cols    []*pgxColumn
+	colIdxs []int // the DataRow index of each of cols
+	targets []interface{}
+	err     error
+}
+
+func (it *pgxRows) Next() bool {
+	if it.err != nil || !it.rows.Next() {
+		return false
+	}
+	it.err = it.rows.Scan(it.targets...)
+	for idx, col := range it.cols {
+		if it.err != nil {
+			break
+		}
+		it.err = col.assign((*it.dR)[it.colIdxs[idx]])
+	}
+	return it.err == nil
+}
+
+func (it *pgxRows) Row() *DataRow {
+	return it.dR
+}
+
+func (it *pgxRows) Err() error {
+	if it.err != nil {
+		return it.err
+	}
+	return it.rows.Err()
+}
+
+func (it *pgxRows) Close() error {
+	it.rows.Close()
+	return nil
+}
+
+func newPgxColumns(fields []pgx.FieldDescription) ([]*pgxColumn, error) {
+	var cols []*pgxColumn
+	for _, fd := range fields {
+		value, err := newPgxValue(fd.DataType)
+		if err != nil {
+			return nil, fmt.Errorf("Column %q: %s", fd.Name, err.Error())
+		}
+		cols = append(cols, &pgxColumn{name: fd.Name, value: value})
+	}
+	return cols, nil
+}
+
+func newPgxValue(oid pgtype.OID) (interface{}, error) {
+	switch oid {
+	case pgtype.BoolOID:
+		return &pgtype.Bool{}, nil
+	case pgtype.Int2OID:
+		return &pgtype.Int2{}, nil
+	case pgtype.Int4OID:
+		return &pgtype.Int4{}, nil
+	case pgtype.Int8OID:
+		return &pgtype.Int8{}, nil
+	case pgtype.Float4OID:
+		return &pgtype.Float4{}, nil
+	case pgtype.Float8OID:
+		return &pgtype.Float8{}, nil
+	case pgtype.NumericOID:
+		return &pgtype.Numeric{}, nil
+	case pgtype.TextOID, pgtype.VarcharOID, pgtype.BPCharOID, pgtype.NameOID:
+		return &pgtype.Text{}, nil
+	case pgtype.DateOID:
+		return &pgtype.Date{}, nil
+	case pgtype.TimestampOID:
+		return &pgtype.Timestamp{}, nil
+	case pgtype.TimestamptzOID:
+		return &pgtype.Timestamptz{}, nil
+	}
+	return nil, fmt.Errorf("Unsupported column type (oid %d)", oid)
+}
+
+// assign converts the scanned value to the type of dv
+func (col *pgxColumn) assign(dv *DataVal) error {
+	var err error
+	switch src := col.value.(type) {
+	case *pgtype.Bool:
+		err = assignPgx(dv, src.Status, src.Bool)
+	case *pgtype.Int2:
+		err = assignPgx(dv, src.Status, src.Int)
+	case *pgtype.Int4:
+		err = assignPgx(dv, src.Status, src.Int)
+	case *pgtype.Int8:
+		err = assignPgx(dv, src.Status, src.Int)
+	case *pgtype.Float4:
+		err = assignPgx(dv, src.Status, src.Float)
+	case *pgtype.Float8:
+		err = assignPgx(dv, src.Status, src.Float)
+	case *pgtype.Text:
+		err = assignPgx(dv, src.Status, src.String)
+	case *pgtype.Date:
+		err = assignPgx(dv, src.Status, src.Time)
+	case *pgtype.Timestamp:
+		err = assignPgx(dv, src.Status, src.Time)
+	case *pgtype.Timestamptz:
+		err = assignPgx(dv, src.Status, src.Time)
+	case *pgtype.Numeric:
+		err = assignNumeric(dv, src)
+	}
+	if err != nil {
+		return fmt.Errorf("Column %q: %s", col.name, err.Error())
+	}
+	return nil
+}
+
+func assignPgx(dv *DataVal, status pgtype.Status, v interface{}) error {
+	if status != pgtype.Present {
+		v = nil
+	}
+	return dv.SetValue(v)
+}
+
+// numeric currency amounts are pennies, see DVCurrency
+func assignNumeric(dv *DataVal, src *pgtype.Numeric) error {
+	if src.Status != pgtype.Present {
+		return dv.SetValue(nil)
+	}
+	switch dv.Typ {
+	case DVCurrency:
+		pennies, err := numericPennies(src)
+		if err != nil {
+			return err
+		}
+		return dv.SetValue(pennies)
+	case DVInt:
+		var val int64
+		if err := src.AssignTo(&val); err != nil {
+			return err
+		}
+		return dv.SetValue(val)
+	}
+	var val float64
+	if err := src.AssignTo(&val); err != nil {
+		return err
+	}
+	return dv.SetValue(val)
+}
+
+// rounds a fraction of a penny half away from zero
+func numericPennies(src *pgtype.Numeric) (int64, error) {
+	ten := big.NewInt(10)
+	exp := int64(src.Exp)
+	val := new(big.Int).Set(src.Int)
+	if exp >= 0 {
+		val.Mul(val, new(big.Int).Exp(ten, big.NewInt(exp), nil))
+	} else {
+		div := new(big.Int).Exp(ten, big.NewInt(-exp), nil)
+		rem := new(big.Int)
+		val.QuoRem(val, div, rem)
+		rem.Abs(rem).Mul(rem, big.NewInt(2))
+		if rem.Cmp(div) >= 0 {
+			if src.Int.Sign() < 0 {
+				val.Sub(val, big.NewInt(1))
+			} else {
+				val.Add(val, big.NewInt(1))
+			}
+		}
+	}
+	if !val.IsInt64() {
+		return 0, fmt.Errorf("Numeric value out of range for currency")
+	}
+	return val.Int64(), nil
+}
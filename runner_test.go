@@ -0,0 +1,91 @@
+package repmeta
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func salesReport() *ReportSpec {
+	return &ReportSpec{
+		Dataset:      salesDataset(),
+		Columns:      []ColumnSpec{{FldName: "id", CalcType: "count"}, {FldName: "amount", CalcType: "sum"}},
+		Groups:       []string{"region"},
+		ExtraColumns: []string{"region"},
+		Filters:      []FilterSpec{{FldName: "region", Op: "exists", Options: []string{"not"}}},
+	}
+}
+
+// salesTotals are the totals of salesReport
+var salesTotals = []ReportRow{
+	{RowType: "SUM", RowLevel: 1, LevelName: "East", LevelCount: 3, Values: []string{"", "3", "35.00"}},
+	{RowType: "SUM", RowLevel: 1, LevelName: "West", LevelCount: 3, Values: []string{"", "3", "127.00"}},
+	{RowType: "TOT", RowLevel: 0, LevelName: "Grand Totals", LevelCount: 6, Values: []string{"", "6", "162.00"}},
+}
+
+// summaryRows reads the JSON rows of a report that are not detail rows
+func summaryRows(t *testing.T, b *bytes.Buffer) []ReportRow {
+	t.Helper()
+	allRows := []ReportRow{}
+	scanner := bufio.NewScanner(b)
+	for scanner.Scan() {
+		var row ReportRow
+		if err := json.Unmarshal(scanner.Bytes(), &row); err != nil {
+			t.Fatal(err)
+		}
+		if row.RowType == "SUM" || row.RowType == "TOT" {
+			allRows = append(allRows, row)
+		}
+	}
+	return allRows
+}
+
+func TestRunSQLite(t *testing.T) {
+	db := openSalesDB(t)
+	spec := salesReport()
+
+	var b bytes.Buffer
+	rW := NewReportWriter(zap.NewNop().Sugar(), &b, OTJSON, "sales", true, spec, nil, "")
+	numRows, err := RunWith(context.Background(), db, spec, rW, QueryOptions{Dialect: SQLite{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if numRows != 6 {
+		t.Errorf("Read %d rows, expected 6", numRows)
+	}
+
+	if got := summaryRows(t, &b); !reflect.DeepEqual(got, salesTotals) {
+		t.Errorf("Totals %v, expected %v", got, salesTotals)
+	}
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, fmt.Errorf("Disk full")
+}
+
+func TestRunSQLiteFails(t *testing.T) {
+	db := openSalesDB(t)
+	ctx := context.Background()
+
+	spec := salesReport()
+	rW := NewReportWriter(zap.NewNop().Sugar(), failingWriter{}, OTJSON, "sales", false, spec, nil, "")
+	if _, err := RunWith(ctx, db, spec, rW, QueryOptions{Dialect: SQLite{}}); err == nil {
+		t.Error("Writing to a failing writer succeeded")
+	}
+
+	spec = salesReport()
+	spec.Dataset.ViewName = "no_such_view"
+	var b bytes.Buffer
+	rW = NewReportWriter(zap.NewNop().Sugar(), &b, OTJSON, "sales", false, spec, nil, "")
+	if _, err := RunWith(ctx, db, spec, rW, QueryOptions{Dialect: SQLite{}}); err == nil {
+		t.Error("Querying a missing view succeeded")
+	}
+}
@@ -0,0 +1,165 @@
+package repmeta
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// SortSpec.Direction and SortSpec.Nulls values
+const (
+	SortAsc    = "asc"
+	SortDesc   = "desc"
+	NullsFirst = "first"
+	NullsLast  = "last"
+)
+
+// SortSpec orders the rows within their groups. Sorts follow the Groups
+// in the order by clause.
+type SortSpec struct {
+	FldName   string
+	Direction string // asc when empty
+	Nulls     string // the database's default placement when empty
+}
+
+func (ss SortSpec) String() string {
+	s := fmt.Sprintf("%s %s", ss.FldName, ss.direction())
+	if len(ss.Nulls) > 0 {
+		s = fmt.Sprintf("%s nulls %s", s, ss.nulls())
+	}
+	return s
+}
+
+func (ss SortSpec) direction() string {
+	if len(ss.Direction) == 0 {
+		return SortAsc
+	}
+	return strings.ToLower(ss.Direction)
+}
+
+func (ss SortSpec) nulls() string {
+	return strings.ToLower(ss.Nulls)
+}
+
+func (ss SortSpec) IsDesc() bool {
+	return ss.direction() == SortDesc
+}
+
+// nullsLast reports where ss places nulls: by its Nulls, or else where
+// the database d places them
+func (ss SortSpec) nullsLast(d Dialect) bool {
+	switch ss.nulls() {
+	case NullsFirst:
+		return false
+	case NullsLast:
+		return true
+	}
+	return ss.IsDesc() != d.NullsSortHigh()
+}
+
+// check reports an unknown Direction or Nulls
+func (ss SortSpec) check() error {
+	switch ss.direction() {
+	case SortAsc, SortDesc:
+	default:
+		return fmt.Errorf("Unknown sort direction %q for field %q", ss.Direction, ss.FldName)
+	}
+	switch ss.nulls() {
+	case "", NullsFirst, NullsLast:
+	default:
+		return fmt.Errorf("Unknown nulls placement %q for field %q", ss.Nulls, ss.FldName)
+	}
+	return nil
+}
+
+func ascending(fldNames []string) []SortSpec {
+	allSorts := []SortSpec{}
+	for _, fldName := range fldNames {
+		allSorts = append(allSorts, SortSpec{FldName: fldName})
+	}
+	return allSorts
+}
+
+func sortFldNames(allSorts []SortSpec) []string {
+	fldNames := []string{}
+	for _, ss := range allSorts {
+		fldNames = append(fldNames, ss.FldName)
+	}
+	return fldNames
+}
+
+// OrderSpecs lists the order of the rows of spec: its Groups ascending,
+// then its Sorts
+func (spec *ReportSpec) OrderSpecs() []SortSpec {
+	return append(ascending(spec.Groups), spec.Sorts...)
+}
+
+// sortKey is a SortSpec resolved to the index of a value within a DataRow
+type sortKey struct {
+	idx       int
+	isDesc    bool
+	nullsLast bool
+}
+
+// newSortKey places nulls where the database d would, when ss leaves
+// them to it
+func newSortKey(idx int, ss SortSpec, d Dialect) sortKey {
+	return sortKey{idx: idx, isDesc: ss.IsDesc(), nullsLast: ss.nullsLast(d)}
+}
+
+func (key sortKey) compare(dvI *DataVal, dvJ *DataVal) int {
+	isNullI, isNullJ := dvI.IsNull(), dvJ.IsNull()
+	if isNullI || isNullJ {
+		if isNullI == isNullJ {
+			return 0
+		}
+		if isNullI == key.nullsLast {
+			return 1
+		}
+		return -1
+	}
+	cmp := dvI.Compare(dvJ)
+	if key.isDesc {
+		return -cmp
+	}
+	return cmp
+}
+
+func sortRows(rows []*DataRow, allKeys []sortKey) {
+	if len(allKeys) == 0 {
+		return
+	}
+	sort.SliceStable(rows, func(i, j int) bool {
+		for _, key := range allKeys {
+			cmp := key.compare((*rows[i])[key.idx], (*rows[j])[key.idx])
+			if cmp != 0 {
+				return cmp < 0
+			}
+		}
+		return false
+	})
+}
+
+// sortDialect is the dialect whose null placement rows sorted in memory
+// follow: the dataset's, or else PostgreSQL's, the default dialect
+func (spec *ReportSpec) sortDialect() Dialect {
+	d, err := spec.Dataset.SQLDialect()
+	if err != nil {
+		return PostgreSQL{}
+	}
+	return d
+}
+
+// datasetSortKeys resolves the order of spec for rows of every field of
+// the dataset (see NewDatasetRow)
+func datasetSortKeys(spec *ReportSpec) []sortKey {
+	d := spec.sortDialect()
+	allKeys := []sortKey{}
+	for _, ss := range spec.OrderSpecs() {
+		fldIdx, _ := spec.Dataset.FieldNamed(ss.FldName)
+		if fldIdx >= 0 {
+			allKeys = append(allKeys, newSortKey(fldIdx, ss, d))
+		}
+	}
+	return allKeys
+}
@@ -0,0 +1,88 @@
+package repmeta
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+// salesCSV is salesRows as a CSV file, nulls as empty cells
+func salesCSV(ds *DatasetSpec) string {
+	var sb strings.Builder
+	allNames := []string{}
+	for _, fld := range ds.Fields {
+		allNames = append(allNames, fld.FldName)
+	}
+	sb.WriteString(strings.Join(allNames, ","))
+	for _, row := range salesRows {
+		allCells := []string{}
+		for _, val := range row {
+			cell := ""
+			if val != nil {
+				cell = fmt.Sprint(val)
+			}
+			allCells = append(allCells, cell)
+		}
+		sb.WriteString("\n" + strings.Join(allCells, ","))
+	}
+	return sb.String()
+}
+
+// csvIDs reads the ids of salesRows from a CSVSource, in its order
+func csvIDs(t *testing.T, spec *ReportSpec) []int64 {
+	t.Helper()
+	src := &CSVSource{Reader: strings.NewReader(salesCSV(&spec.Dataset))}
+	it, err := src.Rows(context.Background(), spec, zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer it.Close()
+	idIdx, _ := spec.ColumnNamed("id")
+	ids := []int64{}
+	for it.Next() {
+		id, _ := (*it.Row())[idIdx].Int64()
+		ids = append(ids, id)
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return ids
+}
+
+// a file source places nulls where the dataset's database would
+func TestFileOrderMatchesQuery(t *testing.T) {
+	db := openSalesDB(t)
+	allOrders := [][]SortSpec{
+		nil,
+		{{FldName: "amount"}},
+		{{FldName: "amount", Direction: SortDesc}},
+		{{FldName: "sold"}, {FldName: "customer", Direction: SortDesc}},
+		{{FldName: "customer", Nulls: NullsLast}},
+	}
+	for _, allSorts := range allOrders {
+		spec := keysetReport(allSorts)
+		want, _ := queryIDs(t, db, spec, -1, QueryOptions{Dialect: SQLite{}})
+		if got := csvIDs(t, spec); !reflect.DeepEqual(got, want) {
+			t.Errorf("%v: %v, the query %v", allSorts, got, want)
+		}
+	}
+
+	for _, tc := range []struct {
+		allSorts []SortSpec
+		want     []int64
+	}{
+		{[]SortSpec{{FldName: "amount"}}, []int64{3, 1, 2, 4, 6, 5, 7}},
+		{[]SortSpec{{FldName: "amount", Direction: SortDesc}}, []int64{2, 1, 3, 5, 6, 4, 7}},
+		{[]SortSpec{{FldName: "amount", Nulls: NullsFirst}}, []int64{3, 1, 2, 5, 4, 6, 7}},
+	} {
+		spec := keysetReport(tc.allSorts)
+		spec.Dataset.Dialect = "postgres"
+		if got := csvIDs(t, spec); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("PostgreSQL %v: %v, expected %v", tc.allSorts, got, tc.want)
+		}
+	}
+}
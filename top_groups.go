@@ -0,0 +1,108 @@
+package repmeta
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// TopGroupSpec keeps the Count groups of one level with the largest
+// totals of a column within each parent group, e.g. the top 10 products
+// by revenue within each region. The rest are written as a single group
+// named OtherName, totalled together, so the groups of a level still add
+// up to their parent's totals. Groups are written in order of their rank.
+type TopGroupSpec struct {
+	Group     string // one of the spec's Groups
+	FldName   string // a totalled column, ranked by its total
+	Count     int
+	Direction string // SortDesc when empty, SortAsc for the smallest totals
+	OtherName string // "Other" when empty
+}
+
+func (ts TopGroupSpec) String() string {
+	return fmt.Sprintf("%s: %d by %s %s", ts.Group, ts.Count, ts.FldName, ts.direction())
+}
+
+func (ts TopGroupSpec) direction() string {
+	if len(ts.Direction) == 0 {
+		return SortDesc
+	}
+	return strings.ToLower(ts.Direction)
+}
+
+func (ts TopGroupSpec) otherName() string {
+	if len(ts.OtherName) == 0 {
+		return "Other"
+	}
+	return ts.OtherName
+}
+
+// check reports a TopGroupSpec that cannot be ranked
+func (ts TopGroupSpec) check(spec *ReportSpec) error {
+	if spec.groupLevel(ts.Group) == 0 {
+		return fmt.Errorf("Top groups of %q, which is not one of the Groups", ts.Group)
+	}
+	if _, _, err := spec.totalField(ts.FldName); err != nil {
+		return err
+	}
+	if ts.Count < 1 {
+		return fmt.Errorf("Top groups of %q need a Count of at least 1", ts.Group)
+	}
+	switch ts.direction() {
+	case SortAsc, SortDesc:
+	default:
+		return fmt.Errorf("Unknown direction %q for the top groups of %q", ts.Direction, ts.Group)
+	}
+	return nil
+}
+
+// topGroupsAt returns the TopGroupSpec of a ReportLevel, nil when the
+// level keeps all of its groups
+func (spec *ReportSpec) topGroupsAt(levelIndex int) *TopGroupSpec {
+	for idx := range spec.TopGroups {
+		if levelIndex > 0 && spec.groupLevel(spec.TopGroups[idx].Group) == levelIndex {
+			return &spec.TopGroups[idx]
+		}
+	}
+	return nil
+}
+
+// checkTopGroups reports the first TopGroupSpec that cannot be ranked
+func (spec *ReportSpec) checkTopGroups() error {
+	seen := map[string]bool{}
+	for _, ts := range spec.TopGroups {
+		if err := ts.check(spec); err != nil {
+			return err
+		}
+		if seen[ts.Group] {
+			return fmt.Errorf("Top groups of %q are given more than once", ts.Group)
+		}
+		seen[ts.Group] = true
+	}
+	return nil
+}
+
+// rankedGroup is a group of a level with TopGroups, held with its output
+// until every group of its parent has been totalled
+type rankedGroup struct {
+	totals *ReportLevel
+	rows   []ReportRow
+}
+
+// rankGroups orders the groups of a level by the total of the ranked
+// column, nulls last. Tied groups keep the order they were read in.
+func rankGroups(allGroups []rankedGroup, ts *TopGroupSpec, colIdx int) {
+	isDesc := ts.direction() == SortDesc
+	sort.SliceStable(allGroups, func(i, j int) bool {
+		valI := (*allGroups[i].totals.Totals)[colIdx]
+		valJ := (*allGroups[j].totals.Totals)[colIdx]
+		if valI.IsNull() || valJ.IsNull() {
+			return !valI.IsNull() && valJ.IsNull()
+		}
+		cmp := valI.Compare(valJ)
+		if isDesc {
+			return cmp > 0
+		}
+		return cmp < 0
+	})
+}
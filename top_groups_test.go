@@ -0,0 +1,93 @@
+package repmeta
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+// topCustomers runs the sales report keeping the top customer of each
+// region by fldName
+func topCustomers(t *testing.T, fldName string) []ReportRow {
+	t.Helper()
+	db := openSalesDB(t)
+	spec := salesReport()
+	spec.Groups = []string{"region", "customer"}
+	spec.ExtraColumns = []string{"region", "customer"}
+	spec.TopGroups = []TopGroupSpec{{Group: "customer", FldName: fldName, Count: 1}}
+
+	var b bytes.Buffer
+	rW := NewReportWriter(zap.NewNop().Sugar(), &b, OTJSON, "sales", true, spec, nil, "")
+	if _, err := RunWith(context.Background(), db, spec, rW, QueryOptions{Dialect: SQLite{}}); err != nil {
+		t.Fatal(err)
+	}
+	return summaryRows(t, &b)
+}
+
+// checkReconciles checks that the customers of each region, Other
+// included, add up to the region's totals
+func checkReconciles(t *testing.T, allRows []ReportRow) {
+	t.Helper()
+	var count int64
+	var amount float64
+	for _, row := range allRows {
+		if row.RowLevel == 2 {
+			count += row.LevelCount
+			if len(row.Values[3]) > 0 {
+				val, err := strconv.ParseFloat(row.Values[3], 64)
+				if err != nil {
+					t.Fatal(err)
+				}
+				amount += val
+			}
+			continue
+		}
+		if row.RowLevel != 1 {
+			continue
+		}
+		if total, _ := strconv.ParseFloat(row.Values[3], 64); count != row.LevelCount || amount != total {
+			t.Errorf("%s: customers hold %d rows of %.2f, the region %d of %s", row.LevelName, count, amount, row.LevelCount, row.Values[3])
+		}
+		count, amount = 0, 0
+	}
+}
+
+func TestTopGroupsReconcile(t *testing.T) {
+	allRows := topCustomers(t, "amount")
+	want := []ReportRow{
+		{RowType: "SUM", RowLevel: 2, LevelName: "acme corp", LevelCount: 1, Values: []string{"", "", "1", "20.00"}},
+		{RowType: "SUM", RowLevel: 2, LevelName: "Other", LevelCount: 2, Values: []string{"", "", "2", "15.00"}},
+		{RowType: "SUM", RowLevel: 1, LevelName: "East", LevelCount: 3, Values: []string{"", "", "3", "35.00"}},
+		{RowType: "SUM", RowLevel: 2, LevelName: "Zed_2", LevelCount: 1, Values: []string{"", "", "1", "120.00"}},
+		{RowType: "SUM", RowLevel: 2, LevelName: "Other", LevelCount: 2, Values: []string{"", "", "2", "7.00"}},
+		{RowType: "SUM", RowLevel: 1, LevelName: "West", LevelCount: 3, Values: []string{"", "", "3", "127.00"}},
+		{RowType: "TOT", RowLevel: 0, LevelName: "Grand Totals", LevelCount: 6, Values: []string{"", "", "6", "162.00"}},
+	}
+	if !reflect.DeepEqual(allRows, want) {
+		t.Errorf("Totals %v, expected %v", allRows, want)
+	}
+	checkReconciles(t, allRows)
+}
+
+// every customer has a count of 1, so the first customer of each region
+// in the order of the query is kept
+func TestTopGroupsTies(t *testing.T) {
+	allRows := topCustomers(t, "id")
+	want := []ReportRow{
+		{RowType: "SUM", RowLevel: 2, LevelName: "Acme", LevelCount: 1, Values: []string{"", "", "1", "10.00"}},
+		{RowType: "SUM", RowLevel: 2, LevelName: "Other", LevelCount: 2, Values: []string{"", "", "2", "25.00"}},
+		{RowType: "SUM", RowLevel: 1, LevelName: "East", LevelCount: 3, Values: []string{"", "", "3", "35.00"}},
+		{RowType: "SUM", RowLevel: 2, LevelName: "", LevelCount: 1, Values: []string{"", "", "1", ""}},
+		{RowType: "SUM", RowLevel: 2, LevelName: "Other", LevelCount: 2, Values: []string{"", "", "2", "127.00"}},
+		{RowType: "SUM", RowLevel: 1, LevelName: "West", LevelCount: 3, Values: []string{"", "", "3", "127.00"}},
+		{RowType: "TOT", RowLevel: 0, LevelName: "Grand Totals", LevelCount: 6, Values: []string{"", "", "6", "162.00"}},
+	}
+	if !reflect.DeepEqual(allRows, want) {
+		t.Errorf("Totals %v, expected %v", allRows, want)
+	}
+	checkReconciles(t, allRows)
+}
@@ -0,0 +1,426 @@
+package repmeta
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Diagnostic is one problem found by ValidateReportSpec. Path locates it
+// in the spec's JSON, e.g. $.Columns[2].FldName
+type Diagnostic struct {
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+// ValidationError holds every problem found in a ReportSpec
+type ValidationError struct {
+	Diagnostics []Diagnostic `json:"diagnostics"`
+}
+
+func (ve *ValidationError) Error() string {
+	allProblems := []string{}
+	for _, diag := range ve.Diagnostics {
+		allProblems = append(allProblems, fmt.Sprintf("%s: %s", diag.Path, diag.Message))
+	}
+	return fmt.Sprintf("Invalid report spec: %s", strings.Join(allProblems, "; "))
+}
+
+// validator collects the diagnostics of one spec
+type validator struct {
+	spec  *ReportSpec
+	diags []Diagnostic
+}
+
+func (v *validator) addf(path string, format string, a ...interface{}) {
+	v.diags = append(v.diags, Diagnostic{Path: path, Message: fmt.Sprintf(format, a...)})
+}
+
+// ValidateReportSpec checks spec against its Dataset before it is run,
+// returning a *ValidationError listing every problem, or nil
+func ValidateReportSpec(spec *ReportSpec) error {
+	v := validator{spec: spec}
+	v.checkDataset()
+	v.checkColumns()
+	v.checkGroups()
+	v.checkSorts()
+	if len(spec.KeyField) > 0 {
+		v.field("$.KeyField", spec.KeyField)
+	}
+	v.checkParameters()
+	for idx, filter := range spec.Filters {
+		v.checkFilter(memberPath("$", "Filters", idx), filter)
+	}
+	if spec.FilterTree != nil {
+		v.checkGroup("$.FilterTree", spec.FilterTree)
+	}
+	v.checkGroupFilters()
+	v.checkTopGroups()
+	if len(v.diags) == 0 {
+		return nil
+	}
+	return &ValidationError{Diagnostics: v.diags}
+}
+
+func (v *validator) checkDataset() {
+	ds := &v.spec.Dataset
+	if len(strings.TrimSpace(ds.ViewName)) == 0 {
+		v.addf("$.Dataset.ViewName", "ViewName is required")
+	}
+	if _, err := ds.SQLDialect(); err != nil {
+		v.addf("$.Dataset.Dialect", "%s", err.Error())
+	}
+	if len(ds.Fields) == 0 {
+		v.addf("$.Dataset.Fields", "Dataset %q has no fields", ds.DatasetName)
+	}
+	seen := map[string]bool{}
+	for idx, fld := range ds.Fields {
+		path := memberPath("$.Dataset", "Fields", idx)
+		if len(fld.FldName) == 0 {
+			v.addf(path+".FldName", "FldName is required")
+		} else if seen[fld.FldName] {
+			v.addf(path+".FldName", "Field %q is declared more than once", fld.FldName)
+		}
+		seen[fld.FldName] = true
+		if ToDataValType(fld.FldType) == DVNone {
+			v.addf(path+".FldType", "Unknown field type %q", fld.FldType)
+		}
+		if len(fld.SelectValue) > 0 {
+			v.field(path+".SelectValue", fld.SelectValue)
+		}
+		if len(fld.SelectName) > 0 {
+			v.field(path+".SelectName", fld.SelectName)
+		}
+		if len(fld.Expr) > 0 {
+			v.checkExpr(path+".Expr", &ds.Fields[idx])
+		}
+	}
+	v.checkJoins()
+}
+
+// Exprs are checked for the Dataset's dialect, queries check them again
+// for the dialect they are rendered in
+func (v *validator) checkExpr(path string, fld *FieldSpec) {
+	ds := &v.spec.Dataset
+	d, _ := ds.SQLDialect()
+	if _, _, err := ds.parseFieldExpr(d, fld); err != nil {
+		v.addf(path, "%s", err.Error())
+		return
+	}
+	if len(fld.Column) > 0 || len(fld.Table) > 0 {
+		v.addf(path, "Field %q has both an Expr and a Column or Table", fld.FldName)
+	}
+	// other problems are reported at the field they belong to
+	var cycle *exprCycle
+	if err := ds.checkExpr(d, fld.FldName); errors.As(err, &cycle) {
+		v.addf(path, "%s", err.Error())
+	}
+}
+
+func (v *validator) checkJoins() {
+	ds := &v.spec.Dataset
+	aliases := []string{ds.baseAlias()}
+	for idx, join := range ds.Joins {
+		path := memberPath("$.Dataset", "Joins", idx)
+		if len(strings.TrimSpace(join.Table)) == 0 {
+			v.addf(path+".Table", "Table is required")
+		}
+		if len(strings.TrimSpace(join.Alias)) == 0 {
+			v.addf(path+".Alias", "Alias is required")
+		} else if containsString(aliases, join.Alias) {
+			v.addf(path+".Alias", "Alias %q is used more than once", join.Alias)
+		}
+		switch join.joinType() {
+		case JoinInner, JoinLeft:
+		default:
+			v.addf(path+".Type", "Unknown join type %q, expected inner or left", join.Type)
+		}
+		if len(join.On) == 0 {
+			v.addf(path+".On", "At least one key is required")
+		}
+		for keyIdx, key := range join.On {
+			keyPath := memberPath(path, "On", keyIdx)
+			alias, column := key.fromParts()
+			if len(column) == 0 {
+				v.addf(keyPath+".From", "From is required")
+			} else if len(alias) > 0 && !containsString(aliases, alias) {
+				v.addf(keyPath+".From", "%q is not the alias of the view or an earlier join", alias)
+			}
+			if len(key.To) == 0 {
+				v.addf(keyPath+".To", "To is required")
+			}
+		}
+		aliases = append(aliases, join.Alias)
+	}
+	for idx, fld := range ds.Fields {
+		if len(fld.Table) > 0 && !containsString(aliases, fld.Table) {
+			v.addf(memberPath("$.Dataset", "Fields", idx)+".Table", "%q is not the alias of the view or a join", fld.Table)
+		}
+	}
+}
+
+// field reports a diagnostic at path when name is not a field of the dataset
+func (v *validator) field(path string, name string) *FieldSpec {
+	idx, pFld := v.spec.Dataset.FieldNamed(name)
+	if idx == -1 {
+		v.addf(path, "%q is not a field of dataset %q", name, v.spec.Dataset.DatasetName)
+		return nil
+	}
+	return pFld
+}
+
+func (v *validator) checkColumns() {
+	if len(v.spec.Columns) == 0 {
+		v.addf("$.Columns", "At least one column is required")
+	}
+	seen := map[string]bool{}
+	for idx, column := range v.spec.Columns {
+		path := memberPath("$", "Columns", idx)
+		if column.IsComputed() {
+			v.checkComputed(path, idx, seen)
+			continue
+		}
+		pFld := v.field(path+".FldName", column.FldName)
+		if pFld == nil {
+			continue
+		}
+		if seen[column.FldName] {
+			v.addf(path+".FldName", "Column %q is listed more than once", column.FldName)
+		}
+		seen[column.FldName] = true
+
+		calc := column.CalcType
+		if len(calc) == 0 || calc == CalcNone {
+			continue
+		}
+		if !IsCalcType(calc) {
+			v.addf(path+".CalcType", "Unknown CalcType %q, expected one of %s", calc, strings.Join(CalcTypes(), ", "))
+			continue
+		}
+		if CalcResultType(calc, ToDataValType(pFld.FldType)) == DVNone {
+			v.addf(path+".CalcType", "CalcType %q cannot total %s field %q", calc, pFld.FldType, pFld.FldName)
+		}
+	}
+	for idx, name := range v.spec.ExtraColumns {
+		v.field(memberPath("$", "ExtraColumns", idx), name)
+	}
+}
+
+func (v *validator) checkComputed(path string, specIdx int, seen map[string]bool) {
+	column := v.spec.Columns[specIdx]
+	if len(column.FldName) == 0 {
+		v.addf(path+".FldName", "FldName is required")
+	} else if idx, _ := v.spec.Dataset.FieldNamed(column.FldName); idx >= 0 {
+		v.addf(path+".FldName", "Computed column %q has the name of a field", column.FldName)
+	} else if seen[column.FldName] {
+		v.addf(path+".FldName", "Column %q is listed more than once", column.FldName)
+	}
+	seen[column.FldName] = true
+	if len(column.CalcType) > 0 && column.CalcType != CalcNone {
+		v.addf(path+".CalcType", "Computed column %q is recomputed on totals, it takes no CalcType", column.FldName)
+	}
+	if !Numerics()[column.computedType()] {
+		v.addf(path+".FldType", "Computed column %q must be int, float or currency", column.FldName)
+	}
+	if _, err := compileColumn(v.spec, specIdx); err != nil {
+		v.addf(path+".Expr", "%s", err.Error())
+	}
+}
+
+func (v *validator) checkGroups() {
+	for idx, name := range v.spec.Groups {
+		path := memberPath("$", "Groups", idx)
+		pFld := v.field(path, name)
+		if pFld != nil && !pFld.CanGroup {
+			v.addf(path, "Field %q cannot be grouped", name)
+		}
+	}
+}
+
+func (v *validator) checkSorts() {
+	for idx, ss := range v.spec.Sorts {
+		path := memberPath("$", "Sorts", idx)
+		v.field(path+".FldName", ss.FldName)
+		switch ss.direction() {
+		case SortAsc, SortDesc:
+		default:
+			v.addf(path+".Direction", "Unknown sort direction %q, expected asc or desc", ss.Direction)
+		}
+		switch ss.nulls() {
+		case "", NullsFirst, NullsLast:
+		default:
+			v.addf(path+".Nulls", "Unknown nulls placement %q, expected first or last", ss.Nulls)
+		}
+	}
+}
+
+func (v *validator) checkParameters() {
+	seen := map[string]bool{}
+	for idx := range v.spec.Parameters {
+		param := &v.spec.Parameters[idx]
+		path := memberPath("$", "Parameters", idx)
+		if len(param.ParamName) == 0 {
+			v.addf(path+".ParamName", "ParamName is required")
+		} else if seen[param.ParamName] {
+			v.addf(path+".ParamName", "Parameter %q is declared more than once", param.ParamName)
+		}
+		seen[param.ParamName] = true
+		if ToDataValType(param.paramType()) == DVNone {
+			v.addf(path+".ParamType", "Unknown parameter type %q", param.ParamType)
+			continue
+		}
+		for valIdx, value := range param.Default {
+			if _, err := TypedValue(value, param.paramType()); err != nil {
+				v.addf(memberPath(path, "Default", valIdx), "%s", err.Error())
+			}
+		}
+		for valIdx, value := range param.Allowed {
+			if _, err := TypedValue(value, param.paramType()); err != nil {
+				v.addf(memberPath(path, "Allowed", valIdx), "%s", err.Error())
+			}
+		}
+	}
+}
+
+func (v *validator) checkGroup(path string, fg *FilterGroup) {
+	if _, err := fg.groupOp(); err != nil {
+		v.addf(path+".Op", "%s", err.Error())
+	}
+	for idx, filter := range fg.Filters {
+		v.checkFilter(memberPath(path, "Filters", idx), filter)
+	}
+	for idx := range fg.Groups {
+		v.checkGroup(memberPath(path, "Groups", idx), &fg.Groups[idx])
+	}
+}
+
+func (v *validator) checkFilter(path string, fs FilterSpec) {
+	pFld := v.field(path+".FldName", fs.FldName)
+	if pFld == nil {
+		return
+	}
+	if !pFld.CanFilter {
+		v.addf(path+".FldName", "Field %q cannot be filtered", fs.FldName)
+	}
+
+	isDateOp := IsRelativeDateOp(fs.Op)
+	opcode, _ := OpCodeSQL(fs.Op, false)
+	switch {
+	case isDateOp && pFld.FldType != "date":
+		v.addf(path+".Op", "Op %q requires a date field, %q is %s", fs.Op, fs.FldName, pFld.FldType)
+		return
+	case isDateOp:
+	case opcode == "":
+		v.addf(path+".Op", "Unknown op %q", fs.Op)
+		return
+	case strings.HasSuffix(opcode, "like") && pFld.FldType != "text":
+		v.addf(path+".Op", "Op %q requires a text field, %q is %s", fs.Op, fs.FldName, pFld.FldType)
+		return
+	}
+
+	for idx, option := range fs.Options {
+		if !strings.EqualFold(option, "not") {
+			v.addf(memberPath(path, "Options", idx), "Unknown option %q", option)
+		}
+	}
+	v.checkFilterValues(path, fs, pFld, isDateOp)
+}
+
+func (v *validator) checkFilterValues(path string, fs FilterSpec, pFld *FieldSpec, isDateOp bool) {
+	numValues := len(fs.Values)
+	switch {
+	case fs.Op == "exists" && numValues > 0:
+		v.addf(path+".Values", "Op %q takes no values", fs.Op)
+	case fs.Op == DateLastNDays || fs.Op == DateLastNMonths:
+		if numValues != 1 {
+			v.addf(path+".Values", "Op %q takes a single count", fs.Op)
+		}
+	case isDateOp && numValues > 0:
+		v.addf(path+".Values", "Op %q takes no values", fs.Op)
+	case fs.Op == "range" && numValues != 2:
+		v.addf(path+".Values", "Op %q takes 2 values, %d provided", fs.Op, numValues)
+	case fs.Op != "exists" && !isDateOp && numValues == 0:
+		v.addf(path+".Values", "Op %q requires a value", fs.Op)
+	}
+
+	isLike := fs.Op == "prefix" || fs.Op == "suffix" || fs.Op == "contains"
+	for idx, value := range fs.Values {
+		valPath := memberPath(path, "Values", idx)
+		if name, isRef := ParamRef(value); isRef {
+			if v.spec.ParamNamed(name) == nil {
+				v.addf(valPath, "Parameter %q is not declared", name)
+			}
+			continue
+		}
+		if isLike || isDateOp {
+			continue
+		}
+		if _, err := TypedValue(value, pFld.FldType); err != nil {
+			v.addf(valPath, "%s", err.Error())
+		}
+	}
+	if fs.Op == DateLastNDays || fs.Op == DateLastNMonths {
+		if _, err := relativeCount(fs.Op, fs.Values); err != nil && numValues == 1 && !isParamRef(fs.Values[0]) {
+			v.addf(memberPath(path, "Values", 0), "%s", err.Error())
+		}
+	}
+}
+
+func (v *validator) checkGroupFilters() {
+	for idx, gf := range v.spec.GroupFilters {
+		path := memberPath("$", "GroupFilters", idx)
+		if v.spec.groupLevel(gf.Group) == 0 {
+			v.addf(path+".Group", "Group filter on %q, which is not one of the Groups", gf.Group)
+		}
+		if len(gf.Filters) == 0 {
+			v.addf(path+".Filters", "Group filter on %q has no filters", gf.Group)
+		}
+		for fltIdx, fs := range gf.Filters {
+			fltPath := memberPath(path, "Filters", fltIdx)
+			_, pTotal, err := v.spec.totalField(fs.FldName)
+			if err != nil {
+				v.addf(fltPath+".FldName", "%s", err.Error())
+				continue
+			}
+			if !IsTotalOp(fs.Op) {
+				v.addf(fltPath+".Op", "Op %q cannot test the total of %q", fs.Op, fs.FldName)
+				continue
+			}
+			for optIdx, option := range fs.Options {
+				if !strings.EqualFold(option, "not") {
+					v.addf(memberPath(fltPath, "Options", optIdx), "Unknown option %q", option)
+				}
+			}
+			v.checkFilterValues(fltPath, fs, pTotal, false)
+		}
+	}
+}
+
+func (v *validator) checkTopGroups() {
+	seen := map[string]bool{}
+	for idx, ts := range v.spec.TopGroups {
+		path := memberPath("$", "TopGroups", idx)
+		if v.spec.groupLevel(ts.Group) == 0 {
+			v.addf(path+".Group", "Top groups of %q, which is not one of the Groups", ts.Group)
+		} else if seen[ts.Group] {
+			v.addf(path+".Group", "Top groups of %q are given more than once", ts.Group)
+		}
+		seen[ts.Group] = true
+		if _, _, err := v.spec.totalField(ts.FldName); err != nil {
+			v.addf(path+".FldName", "%s", err.Error())
+		}
+		if ts.Count < 1 {
+			v.addf(path+".Count", "Top groups of %q need a Count of at least 1", ts.Group)
+		}
+		switch ts.direction() {
+		case SortAsc, SortDesc:
+		default:
+			v.addf(path+".Direction", "Unknown direction %q, expected asc or desc", ts.Direction)
+		}
+	}
+}
+
+func isParamRef(v string) bool {
+	_, isRef := ParamRef(v)
+	return isRef
+}
@@ -37,11 +37,17 @@ type ReportWriter struct {
 	grandTotals     *ReportLevel
 	suppressDetails bool
 	wantDashes      bool
+	computed        []computedColumn // evaluated on details and totals
+	groupFilters    []RowPredicate   // by level, nil for levels not filtered
+	held            []ReportRow      // rows of groups not yet known to pass
+	holds           []int            // where each filtered open group starts in held
+	ranked          [][]rankedGroup  // by level, the closed groups to be ranked
   s3Client        *s3.Client
   bucketName      string
   uploadId        string
   parts           []types.CompletedPart
   streamBuf       *bytes.Buffer
+	err             error // first output error, see Err
 }
 
 type ReportRow struct {
@@ -52,7 +58,24 @@ type ReportRow struct {
 	Values     []string `json:"val" msgpack:"val"`
 }
 
+// Err returns the first error encountered while writing the report
+func (rW *ReportWriter) Err() error {
+	return rW.err
+}
+
+func (rW *ReportWriter) keepErr(err error) error {
+	if err != nil && rW.err == nil {
+		rW.err = err
+	}
+	return err
+}
+
 func (rW *ReportWriter) EmitRow(rowType string, rowLevel int, levelName string, levelCount int64, values []string) error {
+	err := rW.emitRow(rowType, rowLevel, levelName, levelCount, values)
+	return rW.keepErr(err)
+}
+
+func (rW *ReportWriter) emitRow(rowType string, rowLevel int, levelName string, levelCount int64, values []string) error {
 	rOut := ReportRow{
 		RowType:    rowType,
 		RowLevel:   rowLevel,
@@ -60,6 +83,20 @@ func (rW *ReportWriter) EmitRow(rowType string, rowLevel int, levelName string,
 		LevelCount: levelCount,
 		Values:     values,
 	}
+	return rW.emit(rOut)
+}
+
+// emit holds rOut until the footer of its group decides whether it is
+// written, or writes it when no group is held
+func (rW *ReportWriter) emit(rOut ReportRow) error {
+	if len(rW.holds) > 0 {
+		rW.held = append(rW.held, rOut)
+		return nil
+	}
+	return rW.writeRow(rOut)
+}
+
+func (rW *ReportWriter) writeRow(rOut ReportRow) error {
   var oStr string
   var err error
   var oData []byte
@@ -95,30 +132,36 @@ func (rW *ReportWriter) EmitRow(rowType string, rowLevel int, levelName string,
 
     if bufSize > MinS3BufSize {
       // Stream to s3
-      rW.Flush(MinS3BufSize)
+      err = rW.Flush(MinS3BufSize)
       rW.streamBuf.Reset()
     }
 
-    return nil
+    return err
   }
 
-  rW.outwriter.Write(oData)
-	return nil
+  _, err = rW.outwriter.Write(oData)
+	return err
 }
 
 func (rW *ReportWriter) FlushRows() error {
 	if rW.outputType == OTText {
 		tW, ok := rW.outwriter.(*tabwriter.Writer)
 		if ok {
-			tW.Flush()
+			return rW.keepErr(tW.Flush())
 		}
 	}
 	return nil
 }
 
 func (rW *ReportWriter) ProcessGrandTotals() {
-	grandIndex := 0
+	rW.emitRanked(1)
+	computeColumns(rW.computed, rW.grandTotals.Totals)
 	sums := rW.grandTotals.AllTotals()
+	rW.emitGrandTotals(rW.grandTotals.TotCount, sums)
+}
+
+func (rW *ReportWriter) emitGrandTotals(levelCount int64, sums []string) {
+	grandIndex := 0
 	dashes := reptext.AllToChar(sums, '-')
 	ddashes := reptext.AllToChar(sums, '=')
 	summaryText := "Grand Totals"
@@ -126,7 +169,7 @@ func (rW *ReportWriter) ProcessGrandTotals() {
 	if rW.wantDashes {
 		rW.EmitRow("TOT", grandIndex, "", 0, dashes)
 	}
-	rW.EmitRow("TOT", grandIndex, summaryText, rW.grandTotals.TotCount, sums)
+	rW.EmitRow("TOT", grandIndex, summaryText, levelCount, sums)
 	if rW.wantDashes {
 		rW.EmitRow("TOT", grandIndex, "", 0, ddashes)
 	}
@@ -180,6 +223,23 @@ func NewReportWriter(pLogger *zap.SugaredLogger, wx io.Writer, outputType Output
 	// spec, levels
 	var allLevels []*ReportLevel
 	rW.spec = spec
+	computed, err := compileComputed(spec)
+	if err != nil {
+		// reported by Err, so the report fails before its first row
+		rW.logger.Errorf("%s", err.Error())
+		rW.keepErr(err)
+	}
+	rW.computed = computed
+	groupFilters, err := compileGroupFilters(spec)
+	if err != nil {
+		rW.logger.Errorf("%s", err.Error())
+		rW.keepErr(err)
+	}
+	rW.groupFilters = groupFilters
+	if err := spec.checkTopGroups(); err != nil {
+		rW.logger.Errorf("%s", err.Error())
+		rW.keepErr(err)
+	}
 	topLevel, erx := NewReportLevel(spec, "")
 	if erx != nil {
 		rW.logger.Fatalf("Unable to allocate Top Level\n%s\n", erx.Error())
@@ -200,6 +260,7 @@ func NewReportWriter(pLogger *zap.SugaredLogger, wx io.Writer, outputType Output
 	rW.grandTotals = topLevel
 
 	rW.levels = allLevels
+	rW.ranked = make([][]rankedGroup, len(allLevels))
 	rW.suppressDetails = suppressDetails
 	return rW
 }
@@ -255,6 +316,9 @@ func (rW *ReportWriter) ProcessHeaders(startLevel int, lastLevel int, dR *DataRo
 		workLevel := rW.levels[levelIndex]
 		currValue := dR.ValueAtIndex(workLevel.FldIdx)
 		workLevel.PrevValue = currValue
+		if rW.levelFilter(levelIndex) != nil || rW.spec.topGroupsAt(levelIndex) != nil {
+			rW.holds = append(rW.holds, len(rW.held))
+		}
 		if !rW.suppressDetails {
 			rW.EmitRow("HDR", levelIndex, currValue, 0, []string{})
 
@@ -293,19 +357,12 @@ func (rW *ReportWriter) ProcessFooters(startLevel int, lastLevel int) int {
 
 	for levelIndex := lastLevel; levelIndex >= startLevel; levelIndex-- {
 		workLevel := rW.levels[levelIndex]
+		rW.emitRanked(levelIndex + 1)
+		computeColumns(rW.computed, workLevel.Totals)
 		sums := workLevel.AllTotals()
-		dashes := reptext.AllToChar(sums, '-')
-		ddashes := reptext.AllToChar(sums, '=')
 		summaryText := fmt.Sprintf("%s", workLevel.PrevValue)
-
-		if rW.wantDashes {
-			rW.EmitRow("SUM", levelIndex, "", 0, dashes)
-		}
-		rW.EmitRow("SUM", levelIndex, summaryText, workLevel.TotCount, sums)
-		if rW.wantDashes {
-			rW.EmitRow("SUM", levelIndex, "", 0, ddashes)
-			rW.EmitRow("SUM", levelIndex, "", 0, []string{})
-		}
+		rW.emitFooter(levelIndex, summaryText, workLevel.TotCount, sums)
+		rW.closeGroup(levelIndex, workLevel)
 
 		workLevel.ResetNumerics()
 		workLevel.TotCount = 0
@@ -314,6 +371,127 @@ func (rW *ReportWriter) ProcessFooters(startLevel int, lastLevel int) int {
 	return numProcessed
 }
 
+// levelFilter tests the totals of a group level, nil when the level has
+// no GroupFilters
+func (rW *ReportWriter) levelFilter(levelIndex int) RowPredicate {
+	if levelIndex >= len(rW.groupFilters) {
+		return nil
+	}
+	return rW.groupFilters[levelIndex]
+}
+
+// closeGroup decides, once its footer is written, whether the rows held
+// for a group are written, dropped, or kept to be ranked
+func (rW *ReportWriter) closeGroup(levelIndex int, workLevel *ReportLevel) {
+	pred := rW.levelFilter(levelIndex)
+	ts := rW.spec.topGroupsAt(levelIndex)
+	if pred == nil && ts == nil {
+		return
+	}
+	passed := pred == nil || pred(workLevel.Totals)
+	if ts == nil {
+		rW.release(passed)
+		return
+	}
+
+	lastHold := len(rW.holds) - 1
+	start := rW.holds[lastHold]
+	rW.holds = rW.holds[:lastHold]
+	if passed {
+		totals, err := NewReportLevel(rW.spec, workLevel.FldName)
+		if err != nil {
+			rW.keepErr(err)
+			return
+		}
+		totals.Merge(workLevel)
+		totals.PrevValue = workLevel.PrevValue
+		computeColumns(rW.computed, totals.Totals)
+		rows := append([]ReportRow{}, rW.held[start:]...)
+		rW.ranked[levelIndex] = append(rW.ranked[levelIndex], rankedGroup{totals: totals, rows: rows})
+	}
+	rW.held = rW.held[:start]
+}
+
+// emitRanked writes the top groups of a level within the parent group
+// being closed, followed by the rest totalled as a single group
+func (rW *ReportWriter) emitRanked(levelIndex int) {
+	ts := rW.spec.topGroupsAt(levelIndex)
+	if ts == nil || levelIndex >= len(rW.ranked) {
+		return
+	}
+	allGroups := rW.ranked[levelIndex]
+	rW.ranked[levelIndex] = nil
+	colIdx, _, err := rW.spec.totalField(ts.FldName)
+	if err != nil {
+		rW.keepErr(err)
+		return
+	}
+	rankGroups(allGroups, ts, colIdx)
+	for idx := 0; idx < len(allGroups) && idx < ts.Count; idx++ {
+		for _, rOut := range allGroups[idx].rows {
+			rW.keepErr(rW.emit(rOut))
+		}
+	}
+	if len(allGroups) <= ts.Count {
+		return
+	}
+
+	other, err := NewReportLevel(rW.spec, ts.Group)
+	if err != nil {
+		rW.keepErr(err)
+		return
+	}
+	for _, group := range allGroups[ts.Count:] {
+		other.Merge(group.totals)
+	}
+	computeColumns(rW.computed, other.Totals)
+	rW.emitFooter(levelIndex, ts.otherName(), other.TotCount, other.AllTotals())
+}
+
+// release ends the hold of the innermost filtered group, dropping its rows
+// unless it passed. Once no group is held the rows are written.
+func (rW *ReportWriter) release(passed bool) {
+	lastHold := len(rW.holds) - 1
+	if !passed {
+		rW.held = rW.held[:rW.holds[lastHold]]
+	}
+	rW.holds = rW.holds[:lastHold]
+	if len(rW.holds) > 0 {
+		return
+	}
+	for _, rOut := range rW.held {
+		rW.keepErr(rW.writeRow(rOut))
+	}
+	rW.held = nil
+}
+
+func (rW *ReportWriter) emitFooter(levelIndex int, summaryText string, levelCount int64, sums []string) {
+	dashes := reptext.AllToChar(sums, '-')
+	ddashes := reptext.AllToChar(sums, '=')
+
+	if rW.wantDashes {
+		rW.EmitRow("SUM", levelIndex, "", 0, dashes)
+	}
+	rW.EmitRow("SUM", levelIndex, summaryText, levelCount, sums)
+	if rW.wantDashes {
+		rW.EmitRow("SUM", levelIndex, "", 0, ddashes)
+		rW.EmitRow("SUM", levelIndex, "", 0, []string{})
+	}
+}
+
+// WriteTotals writes totals computed elsewhere, e.g. by a rollup query,
+// as the footer of a group level, or as the grand totals for level 0
+func (rW *ReportWriter) WriteTotals(levelIndex int, levelName string, levelCount int64, totals *DataRow) {
+	computeColumns(rW.computed, totals)
+	sums := totals.AllValues()
+	if levelIndex == 0 {
+		rW.emitGrandTotals(levelCount, sums)
+		return
+	}
+	rW.emitFooter(levelIndex, levelName, levelCount, sums)
+	rW.FlushRows()
+}
+
 func DetailWriter(ctx interface{}, dR *DataRow) {
 	rW := ctx.(*ReportWriter)
 	if rW != nil {
@@ -340,6 +518,10 @@ func (rW *ReportWriter) HandleDataRow(dR *DataRow) {
 
 	footerCount := 0
 	changedLevel := -1
+	// the first row opens every group level
+	if isFirst && lastLevel > 0 {
+		changedLevel = 1
+	}
 	if !isFirst && hasLevels {
 		changedLevel = rW.FindFirstChangedLevel(hasLevels, dR)
 		if changedLevel != -1 {
@@ -354,18 +536,30 @@ func (rW *ReportWriter) HandleDataRow(dR *DataRow) {
 	}
 
 	if hasRec {
+		computeColumns(rW.computed, dR)
 		if !rW.suppressDetails {
 			rW.EmitRow("DET", lastLevel, "", 0, dR.AllValues())
 		}
+		// grandTotals is levels[0], so it is accumulated here as well
 		for _, lvl := range rW.levels {
 			lvl.DidAccumulate(dR)
 		}
-		rW.grandTotals.DidAccumulate(dR)
 	}
 
 	rW.FlushRows()
 }
 
+// Finish writes the footers of the groups still open after the last row,
+// followed by the grand totals
+func (rW *ReportWriter) Finish() error {
+	lastLevel := len(rW.levels) - 1
+	if rW.grandTotals.TotCount > 0 && lastLevel > 0 {
+		rW.ProcessFooters(1, lastLevel)
+	}
+	rW.ProcessGrandTotals()
+	return rW.Err()
+}
+
 func (rW *ReportWriter) String() string {
 	var lines []string
 
@@ -475,3 +669,26 @@ func (rW *ReportWriter) Close() error {
   err = rW.CompleteUpload()
   return err
 }
+
+// Abort ends a report that failed part way through. A multipart upload
+// is aborted rather than completed, so S3 drops the parts already sent.
+func (rW *ReportWriter) Abort() error {
+  usesS3 := len(rW.bucketName) > 0
+  if !usesS3 || rW.uploadId == "" {
+    return nil
+  }
+
+  ctx := context.TODO()
+  abort := s3.AbortMultipartUploadInput{
+    Bucket:   aws.String(rW.bucketName),
+    Key:      aws.String(rW.outputName),
+    UploadId: aws.String(rW.uploadId),
+  }
+  _, err := rW.s3Client.AbortMultipartUpload(ctx, &abort)
+  if err != nil {
+    return err
+  }
+
+  rW.logger.Infof("Aborted upload to %s/%s", rW.bucketName, rW.outputName)
+  return nil
+}